@@ -1,16 +1,29 @@
 package build
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/zengchen1024/obs-worker/sdk/binary"
+	"github.com/zengchen1024/obs-worker/sdk/filereceiver"
 	"github.com/zengchen1024/obs-worker/utils"
 	"k8s.io/apimachinery/pkg/util/sets"
 )
 
+const (
+	maxBinaryRetries = 3
+	binaryBatchSize  = 100
+)
+
 type binaryInfo struct {
-	name    string
+	name string
+
+	// hdrmd5 is the md5 of rpm header and payload which is in the meta
+	// of build. It is empty if not a rpm.
 	hdrmd5  string
 	hasMeta bool
 }
@@ -34,67 +47,268 @@ func (b *binaryManager) setKnownBins(prpa string, bins binary.BinaryVersionList)
 	b.knowns[prpa] = bins
 }
 
-func (b *binaryManager) get(dir string, repo *RepoPath, bins []string) (map[string]binaryInfo, error) {
+func (b *binaryManager) newHelper(dir string, repo *RepoPath) *binaryManagerHelper {
 	h := &binaryManagerHelper{
 		dir:         dir,
 		buildHelper: b.buildHelper,
 	}
 	h.init(repo)
 
-	oldCache, toDownload, total := h.getDownloads(bins, b.knowns)
+	return h
+}
 
-	if b.handleCacheHits != nil {
-		b.handleCacheHits(len(oldCache))
+// getAll gets the binaries from the repos. The versions of all the repos
+// are listed concurrently, and each binary is got from the first repo
+// which has it. The binaries are downloaded in parallel batches, and the
+// ones of a failed batch are got from the next repo which has them.
+// The repo is not listed if there is no cache, then it is asked for all
+// the binaries left after the repos before it are done.
+// It fails if the versions of any repo can't be listed, or a binary
+// failed to download is not got from the other repos.
+func (b *binaryManager) getAll(dir string, repos []*RepoPath, bins []string) ([]map[string]binaryInfo, error) {
+	helpers := make([]*binaryManagerHelper, len(repos))
+	versions := make([]map[string]*binary.Binary, len(repos))
+	errs := make([]error, len(repos))
+
+	wg := sync.WaitGroup{}
+
+	for i := range repos {
+		helpers[i] = b.newHelper(dir, repos[i])
+
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			versions[i], errs[i] = helpers[i].getVersions(bins, b.knowns)
+		}(i)
 	}
 
-	cacheDir := b.getCacheDir()
-	var newCaches []cacheBin
+	wg.Wait()
 
-	if n := len(toDownload); n > 0 {
-		if b.handleDownloadDetails != nil {
-			b.handleDownloadDetails(n, total)
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf(
+				"list binaries of %s, err:%s", helpers[i].prpa, err.Error(),
+			)
+		}
+
+		helpers[i].versions = versions[i]
+	}
+
+	tried := make([]sets.String, len(helpers))
+	for i := range tried {
+		tried[i] = sets.NewString()
+	}
+
+	left := sets.NewString(bins...)
+	failed := make(map[string]error)
+	oldCache := []cacheBinInfo{}
+	newCaches := []cacheBin{}
+
+	for {
+		o, tasks, total, n := b.assign(helpers, left, tried)
+		if n == 0 {
+			break
 		}
 
-		if cacheDir != "" {
-			cacheSize := b.getCacheSize()
+		oldCache = append(oldCache, o...)
+
+		v, errs := b.fetch(o, tasks, total)
+		newCaches = append(newCaches, v...)
 
-			if v := total << 10; v*100 > cacheSize {
-				b.cache.pruneCache(cacheSize-v, nil, nil)
+		for i, err := range errs {
+			if err != nil {
+				for _, bin := range tasks[i].bins {
+					failed[bin] = err
+				}
 			}
 		}
 
-		var err error
-		newCaches, err = h.download(toDownload)
-		if err != nil {
-			return nil, err
+		for _, h := range helpers {
+			for k := range h.binaries {
+				left.Delete(k)
+				delete(failed, k)
+			}
 		}
 	}
 
-	if cacheDir != "" {
+	if b.getCacheDir() != "" {
 		b.cache.pruneCache(b.getCacheSize(), oldCache, newCaches)
 	}
 
-	return h.getBinaries(), nil
+	if len(failed) > 0 {
+		names := make([]string, 0, len(failed))
+		for k := range failed {
+			names = append(names, k)
+		}
+
+		sort.Strings(names)
+
+		return nil, fmt.Errorf(
+			"download binaries:%s, err:%s",
+			strings.Join(names, ", "), failed[names[0]].Error(),
+		)
+	}
+
+	r := make([]map[string]binaryInfo, len(helpers))
+	for i, h := range helpers {
+		r[i] = h.getBinaries()
+	}
+
+	return r, nil
+}
+
+// assign assigns each binary left to the first repo which has it and
+// has not been tried for it. The repo which is not listed is assigned
+// all the binaries left, and the repos after it wait for the result.
+// It returns the binaries in the cache, the download tasks, the size
+// to download and the number of assigned binaries.
+func (b *binaryManager) assign(helpers []*binaryManagerHelper, left sets.String, tried []sets.String) (
+	oldCache []cacheBinInfo,
+	tasks []binaryDownloadTask,
+	total int,
+	n int,
+) {
+	todo := sets.NewString(left.UnsortedList()...)
+
+	for i, h := range helpers {
+		bv := h.versions
+
+		assigned := []string{}
+		for _, bin := range todo.List() {
+			if tried[i].Has(bin) {
+				continue
+			}
+
+			if v, ok := bv[bin]; bv == nil || (ok && v.Error == "") {
+				assigned = append(assigned, bin)
+			}
+		}
+
+		if len(assigned) == 0 {
+			continue
+		}
+
+		todo.Delete(assigned...)
+		tried[i].Insert(assigned...)
+		n += len(assigned)
+
+		o, d, size := h.checkCache(assigned, bv)
+
+		oldCache = append(oldCache, o...)
+		total += size
+
+		for start := 0; start < len(d); start += binaryBatchSize {
+			end := start + binaryBatchSize
+			if end > len(d) {
+				end = len(d)
+			}
+
+			tasks = append(tasks, binaryDownloadTask{h: h, bins: d[start:end]})
+		}
+
+		if bv == nil {
+			break
+		}
+	}
+
+	return
+}
+
+type binaryDownloadTask struct {
+	h    *binaryManagerHelper
+	bins []string
+}
+
+// fetch runs the download tasks in parallel. The new caches are
+// returned in the order of tasks, so the cache keeps the repo priority.
+// The errors are returned in the order of tasks too.
+func (b *binaryManager) fetch(oldCache []cacheBinInfo, tasks []binaryDownloadTask, total int) (
+	[]cacheBin, []error,
+) {
+	if b.handleCacheHits != nil {
+		b.handleCacheHits(len(oldCache))
+	}
+
+	b.progress.addCacheHits(len(oldCache))
+
+	n := 0
+	for i := range tasks {
+		n += len(tasks[i].bins)
+	}
+
+	if n == 0 {
+		return nil, nil
+	}
+
+	if b.handleDownloadDetails != nil {
+		b.handleDownloadDetails(n, total)
+	}
+
+	if cacheSize := b.getCacheSize(); b.getCacheDir() != "" {
+		if v := total << 10; v*100 > cacheSize {
+			b.cache.pruneCache(cacheSize-v, nil, nil)
+		}
+	}
+
+	results := make([][]cacheBin, len(tasks))
+	errs := make([]error, len(tasks))
+	limit := make(chan struct{}, b.cfg.BinaryDownloadConcurrency)
+	wg := sync.WaitGroup{}
+
+	for i := range tasks {
+		wg.Add(1)
+		limit <- struct{}{}
+
+		go func(i int) {
+			defer func() {
+				<-limit
+				wg.Done()
+			}()
+
+			t := &tasks[i]
+
+			v, err := t.h.download(t.bins)
+			if err != nil {
+				utils.LogErr("download binaries of %s, err:%s", t.h.prpa, err.Error())
+			}
+
+			results[i], errs[i] = v, err
+		}(i)
+	}
+
+	wg.Wait()
+
+	r := []cacheBin{}
+	for i := range results {
+		r = append(r, results[i]...)
+	}
+
+	return r, errs
 }
 
 type binaryManagerHelper struct {
 	*buildHelper
 
-	nometa     bool
-	dir        string
-	prpa       string
-	project    string
-	repository string
-	repoServer string
+	nometa      bool
+	dir         string
+	prpa        string
+	project     string
+	repository  string
+	repoServers []string
 
+	// lock protects binaries which is set by the parallel downloads.
+	lock     sync.Mutex
 	binaries map[string]binaryInfo
+	versions map[string]*binary.Binary
 }
 
 func (h *binaryManagerHelper) init(repo *RepoPath) {
 	info := h.getBuildInfo()
 	h.prpa = info.getPrpaOfRepo(repo)
 	h.nometa = info.isRepoNoMeta(repo)
-	h.repoServer = info.getRepoServer(repo)
+	h.repoServers = h.getRepoServers(info.getRepoServer(repo))
 
 	h.project = repo.Project
 	h.repository = repo.Repository
@@ -102,10 +316,12 @@ func (h *binaryManagerHelper) init(repo *RepoPath) {
 	h.binaries = make(map[string]binaryInfo)
 }
 
-func (h *binaryManagerHelper) genCommonOpts() binary.CommonOpts {
+func (h *binaryManagerHelper) genCommonOpts(repoServer string) (string, binary.CommonOpts) {
 	info := h.getBuildInfo()
+	endpoint, server := h.getBinaryServer(repoServer)
 
-	return binary.CommonOpts{
+	return endpoint, binary.CommonOpts{
+		Server:     server,
 		WorkerId:   h.cfg.Id,
 		Project:    h.project,
 		Repository: h.repository,
@@ -114,6 +330,34 @@ func (h *binaryManagerHelper) genCommonOpts() binary.CommonOpts {
 	}
 }
 
+// verify removes the binaries whose hdrmd5 is not the expected one.
+func (h *binaryManagerHelper) verify(files []filereceiver.CPIOFileMeta) []filereceiver.CPIOFileMeta {
+	r := make([]filereceiver.CPIOFileMeta, 0, len(files))
+
+	for i := range files {
+		name := files[i].Name
+
+		bin, suffix, ok := isBinFile(name)
+		if ok && suffix == ".rpm" {
+			if v, ok := h.versions[bin]; ok && v.HdrMD5 != "" {
+				f := filepath.Join(h.dir, name)
+
+				if files[i].HdrMD5 != v.HdrMD5 {
+					utils.LogErr("hdrmd5 of %s is not expected, discard it", f)
+
+					os.Remove(f)
+
+					continue
+				}
+			}
+		}
+
+		r = append(r, files[i])
+	}
+
+	return r
+}
+
 func (h *binaryManagerHelper) getBinaries() map[string]binaryInfo {
 	if !h.nometa {
 		return h.binaries
@@ -149,21 +393,40 @@ func (h *binaryManagerHelper) toBinaryMap(bl []binary.Binary) map[string]*binary
 	return bv
 }
 
-func (h *binaryManagerHelper) listBinaries(bins []string) (binary.BinaryVersionList, error) {
-	opts := h.genCommonOpts()
-	opts.Binaries = bins
+func (h *binaryManagerHelper) listBinaries(bins []string) (v binary.BinaryVersionList, err error) {
+	for i := 0; ; i++ {
+		if v, err = h.listBinariesOnce(bins); err == nil {
+			return
+		}
 
-	v, err := binary.List(
-		h.repoServer,
-		&binary.ListOpts{
-			CommonOpts: opts,
-			NoMeta:     h.nometa,
-		},
-	)
+		utils.LogErr("getbinaryversions of %s, err: %s", h.prpa, err.Error())
 
-	if err != nil {
-		utils.LogErr("getbinaryversions, err: %s", err.Error())
+		if i >= maxBinaryRetries || h.isCancel() || !utils.IsServerError(err) {
+			return
+		}
 	}
+}
+
+func (h *binaryManagerHelper) listBinariesOnce(bins []string) (binary.BinaryVersionList, error) {
+	var v binary.BinaryVersionList
+
+	err := h.withFailover(
+		serverKindRepo, "binary versions of "+h.prpa, h.repoServers,
+		func(repoServer string) (err error) {
+			endpoint, opts := h.genCommonOpts(repoServer)
+			opts.Binaries = bins
+
+			v, err = binary.List(
+				h.ctx, endpoint,
+				&binary.ListOpts{
+					CommonOpts: opts,
+					NoMeta:     h.nometa,
+				},
+			)
+
+			return
+		},
+	)
 
 	return v, err
 }
@@ -191,29 +454,34 @@ func (h *binaryManagerHelper) checkByKnowns(
 	return nil
 }
 
-func (h *binaryManagerHelper) getDownloads(
+// getVersions returns nil if there is no cache, then the binaries are
+// downloaded without checking the versions.
+func (h *binaryManagerHelper) getVersions(
 	bins []string,
 	knowns map[string]binary.BinaryVersionList,
-) (
-	oldCache []cacheBinInfo,
-	toDownload []string,
-	size int,
-) {
-	cacheDir := h.getCacheDir()
-
-	bv := h.checkByKnowns(bins, knowns)
-	if bv == nil && cacheDir != "" {
-		if v, err := h.listBinaries(bins); err == nil {
-			bv = h.toBinaryMap(v.Binaries)
-		}
+) (map[string]*binary.Binary, error) {
+	if h.getCacheDir() == "" {
+		return nil, nil
 	}
 
-	if len(bv) == 0 {
-		toDownload = bins
+	if bv := h.checkByKnowns(bins, knowns); bv != nil {
+		return bv, nil
+	}
 
-		return
+	v, err := h.listBinaries(bins)
+	if err != nil {
+		return nil, err
 	}
 
+	return h.toBinaryMap(v.Binaries), nil
+}
+
+// checkCache returns the binaries in the cache and the ones to download.
+func (h *binaryManagerHelper) checkCache(bins []string, bv map[string]*binary.Binary) (
+	oldCache []cacheBinInfo,
+	toDownload []string,
+	size int,
+) {
 	for _, binName := range bins {
 		bin, ok := bv[binName]
 		if !ok {
@@ -268,6 +536,7 @@ func (h *binaryManagerHelper) checkInCache(binName string, bin *binary.Binary) (
 
 	useCache, haveMeta = h.checkMetaInCache(binName, cacheFile, bin)
 
+	// only the rpm is used, the hdrmd5 of others is never matched.
 	if useCache && queryHdrmd5(to) == bin.HdrMD5 {
 		binFile = to
 
@@ -314,17 +583,64 @@ func (h *binaryManagerHelper) checkMetaInCache(binName, cacheFile string, bin *b
 	return
 }
 
+// download retries for the binaries missing when the transfer is
+// interrupted. The binaries received are kept even if it fails.
 func (h *binaryManagerHelper) download(toDownload []string) ([]cacheBin, error) {
-	opts := binary.DownloadOpts{
-		CommonOpts: h.genCommonOpts(),
+	todo := sets.NewString(toDownload...)
+	res := []filereceiver.CPIOFileMeta{}
+	resumed := false
+
+	get := func(repoServer string) error {
+		endpoint, common := h.genCommonOpts(repoServer)
+
+		opts := binary.DownloadOpts{
+			CommonOpts: common,
+			Digests:    filereceiver.DigestHdrMD5,
+		}
+		opts.Binaries = todo.List()
+
+		v, err := binary.Download(h.ctx, endpoint, &opts, h.dir)
+
+		// the files of an interrupted or resumed batch are verified.
+		if err != nil || resumed {
+			v = h.verify(v)
+		}
+
+		resumed = true
+
+		h.progress.addCPIOFiles(v)
+
+		for j := range v {
+			if bin, _, ok := isBinFile(v[j].Name); ok {
+				todo.Delete(bin)
+			}
+		}
+		res = append(res, v...)
+
+		if todo.Len() == 0 {
+			return nil
+		}
+
+		return err
 	}
-	opts.Binaries = toDownload
 
-	res, err := binary.Download(h.repoServer, &opts, h.dir)
-	if err != nil {
+	var err error
+
+	for i := 0; ; i++ {
+		err = h.withFailover(serverKindRepo, "binaries of "+h.prpa, h.repoServers, get)
+		if err == nil || todo.Len() == 0 {
+			err = nil
+
+			break
+		}
+
 		utils.LogErr("call api of getbinaries, err: %s", err)
 
-		return nil, err
+		if i >= maxBinaryRetries || h.isCancel() {
+			break
+		}
+
+		utils.LogInfo("retry downloading %d missing binaries", todo.Len())
 	}
 
 	haveMeta := sets.NewString()
@@ -334,46 +650,43 @@ func (h *binaryManagerHelper) download(toDownload []string) ([]cacheBin, error)
 		name := res[i].Name
 
 		if bin, _, ok := isBinFile(name); ok {
-			tmp := filepath.Join(h.dir, name)
-
-			stat, err := os.Stat(tmp)
-			if err != nil {
-				utils.LogErr("stat %s, err: %s", tmp, err.Error())
-
-				continue
-			}
-
-			md5, err := utils.GenMd5OfFile(tmp)
-			if err != nil {
-				utils.LogErr("gen md5 of %s, err: %s", tmp, err.Error())
-
-				continue
+			// the digests are computed when the file is received.
+			// the hdrmd5 is the id of cache, same as checkInCache.
+			// It is the md5 of file if not a rpm, which is never
+			// used by checkInCache, as the baseline did.
+			id := res[i].HdrMD5
+			if id == "" {
+				id = res[i].MD5
 			}
 
 			newCaches = append(newCaches, cacheBin{
 				cacheBinInfo: cacheBinInfo{
-					Id:   genCacheId(h.prpa, md5),
-					Size: int(stat.Size()),
+					Id:   genCacheId(h.prpa, id),
+					Size: int(res[i].Size),
 				},
-				binFile: tmp,
+				binFile: filepath.Join(h.dir, name),
 			})
 
+			h.lock.Lock()
 			h.binaries[bin] = binaryInfo{
 				name:   name,
-				hdrmd5: md5,
+				hdrmd5: res[i].HdrMD5,
 			}
+			h.lock.Unlock()
 
 		} else if bin, ok := isMetaFile(name); ok {
 			haveMeta.Insert(bin)
 		}
 	}
 
+	h.lock.Lock()
 	for k := range haveMeta {
 		if v, ok := h.binaries[k]; ok {
 			v.hasMeta = true
 			h.binaries[k] = v
 		}
 	}
+	h.lock.Unlock()
 
-	return newCaches, nil
+	return newCaches, err
 }
@@ -13,8 +13,29 @@ const (
 	BuildStagePostBuild = "postbuild"
 )
 
+const (
+	BuildCodeSucceeded = 0
+	BuildCodeFailed    = 1
+	BuildCodeUnchanged = 2
+	BuildCodeBadHost   = 3
+)
+
+func GenResultCode(code int) string {
+	switch code {
+	case BuildCodeSucceeded:
+		return "succeeded"
+	case BuildCodeUnchanged:
+		return "unchanged"
+	case BuildCodeBadHost:
+		return "badhost"
+	default:
+		return "failed"
+	}
+}
+
 type Build interface {
 	DoBuild(string) (int, error)
+	PostBuild(string, int) error
 
 	GetBuildInfo() *buildinfo.BuildInfo
 	Kill() error
@@ -23,6 +44,7 @@ type Build interface {
 	GetBuildLogFile() string
 	CanDo() error
 	GetBuildStage() string
+	GetProgress() Progress
 }
 
 func NewBuild(cfg *Config, info *buildinfo.BuildInfo) (Build, error) {
@@ -60,8 +60,7 @@ func (env *buildEnv) init(cfg *Config) error {
 
 	os.RemoveAll(env.oldpkgdir)
 
-	// obs-build/build need this env
-	return os.Setenv("BUILD_DIR", filepath.Join(cfg.StateDir, "build"))
+	return nil
 }
 
 func createTmpfs(cfg *Config) error {
@@ -1,11 +1,11 @@
 package build
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
 
 	"github.com/zengchen1024/obs-worker/sdk/config"
 	"github.com/zengchen1024/obs-worker/utils"
@@ -17,25 +17,26 @@ type buildHelper struct {
 	env     buildEnv
 	workDir string
 
-	once   sync.Once
-	cancel chan struct{}
+	progress buildProgress
+
+	handleServerUsed func(kind, server string)
+
+	// ctx is canceled when the job is killed, so all the
+	// in-flight downloads of the job are aborted.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func (h *buildHelper) init() {
-	h.cancel = make(chan struct{})
+	h.ctx, h.cancel = context.WithCancel(context.Background())
 }
 
 func (h *buildHelper) isCancel() bool {
-	select {
-	case <-h.cancel:
-		return true
-	default:
-		return false
-	}
+	return h.ctx.Err() != nil
 }
 
 func (h *buildHelper) setCancel() {
-	h.once.Do(func() { close(h.cancel) })
+	h.cancel()
 }
 
 func (h *buildHelper) getBuildInfo() *BuildInfo {
@@ -62,6 +63,25 @@ func (h *buildHelper) getCacheSize() int {
 	return h.cfg.CacheSize
 }
 
+// obs-build/build need this env. It is set per command
+// because the slots of one process use different state dirs.
+func (h *buildHelper) getBuildEnvVars() []string {
+	return []string{
+		"BUILD_DIR=" + filepath.Join(h.cfg.StateDir, "build"),
+	}
+}
+
+// getBinaryServer returns the endpoint of getbinaries and getbinaryversions.
+// Same as the perl worker, they are sent to the binary proxy if it is set,
+// and the proxy forwards them to the server which is passed by parameter.
+func (h *buildHelper) getBinaryServer(repoServer string) (endpoint, server string) {
+	if h.cfg.BinaryProxy == "" {
+		return repoServer, ""
+	}
+
+	return h.cfg.BinaryProxy, repoServer
+}
+
 func (h *buildHelper) getPkgdir() string {
 	return h.env.pkgdir
 }
@@ -71,13 +91,18 @@ func (h *buildHelper) getSrcdir() string {
 }
 
 func (b *buildHelper) downloadProjectConfig() error {
-	return config.Download(
-		b.getSrcServer(),
-		&config.DownloadOpts{
-			Project:    b.info.Project,
-			Repository: b.info.Repository,
+	return b.withFailover(
+		serverKindSource, "project config", b.getSrcServers(),
+		func(endpoint string) error {
+			return config.Download(
+				b.ctx, endpoint,
+				&config.DownloadOpts{
+					Project:    b.info.Project,
+					Repository: b.info.Repository,
+				},
+				b.env.config,
+			)
 		},
-		b.env.config,
 	)
 }
 
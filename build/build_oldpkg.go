@@ -36,11 +36,22 @@ func (b *buildOldPackages) download() error {
 		return name, filepath.Join(dir, name), false, nil
 	}
 
-	r, err := oldpkg.List(info.RepoServer, &opts, check)
+	var r []filereceiver.CPIOFileMeta
+
+	err := b.withFailover(
+		serverKindRepo, "old packages", b.getRepoServers(info.RepoServer),
+		func(endpoint string) (err error) {
+			r, err = oldpkg.List(b.ctx, endpoint, &opts, check)
+
+			return
+		},
+	)
 	if err != nil {
 		return err
 	}
 
+	b.progress.addCPIOFiles(r)
+
 	if len(r) == 0 {
 		return os.Remove(dir)
 	}
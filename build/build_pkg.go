@@ -84,7 +84,7 @@ func (b *buildPkg) kill() error {
 			"--kill",
 		}
 
-		out, err, _ := utils.RunCmd(args...)
+		out, err, _ := utils.RunCmdWithEnv(b.getBuildEnvVars(), args...)
 		if err != nil {
 			return fmt.Errorf("%s, %s", out, err.Error())
 		}
@@ -99,11 +99,11 @@ func (b *buildPkg) build(args []string) (int, error) {
 	utils.LogInfo("start obs-build")
 
 	utils.WriteFile(
-		filepath.Join(b.workDir, "obs-build"),
+		filepath.Join(b.cfg.StateDir, "obs-build"),
 		[]byte(strings.Join(args, "\n")),
 	)
 
-	out, err, code := utils.RunCmd(args...)
+	out, err, code := utils.RunCmdWithEnv(b.getBuildEnvVars(), args...)
 
 	if err != nil {
 		utils.LogInfo("build pkd, err: %s, code: %d", err.Error(), code)
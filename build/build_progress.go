@@ -0,0 +1,151 @@
+package build
+
+import (
+	"encoding/xml"
+	"sync"
+	"time"
+
+	"github.com/zengchen1024/obs-worker/sdk/filereceiver"
+)
+
+const (
+	BuildStepSources         = "sources"
+	BuildStepSSLCert         = "sslcert"
+	BuildStepPreInstallImage = "preinstallimage"
+	BuildStepBinaries        = "binaries"
+	BuildStepOldPackages     = "oldpackages"
+	BuildStepProjectConfig   = "projectconfig"
+	BuildStepRpmlist         = "rpmlist"
+	BuildStepBuild           = "build"
+	BuildStepPostBuild       = "postbuild"
+)
+
+type Progress struct {
+	XMLName xml.Name `xml:"progress" json:"-"`
+
+	Stage     string         `xml:"stage,attr" json:"stage"`
+	Step      string         `xml:"step,attr" json:"step"`
+	Detail    string         `xml:"detail,attr,omitempty" json:"detail,omitempty"`
+	Files     int            `xml:"download>files" json:"downloaded_files"`
+	Bytes     int64          `xml:"download>bytes" json:"downloaded_bytes"`
+	CacheHits int            `xml:"download>cachehits" json:"cache_hits"`
+	Elapsed   int            `xml:"elapsed" json:"elapsed"`
+	Steps     []ProgressStep `xml:"steps>step" json:"steps"`
+}
+
+func (p *Progress) Marshal() ([]byte, error) {
+	return xml.Marshal(p)
+}
+
+// ProgressStep is a finished step or the running one. Elapsed is in seconds.
+type ProgressStep struct {
+	Stage   string `xml:"stage,attr" json:"stage"`
+	Name    string `xml:"name,attr" json:"name"`
+	Detail  string `xml:"detail,attr,omitempty" json:"detail,omitempty"`
+	Elapsed int    `xml:"elapsed,attr" json:"elapsed"`
+}
+
+type stepRecord struct {
+	ProgressStep
+
+	start time.Time
+}
+
+type buildProgress struct {
+	lock sync.Mutex
+
+	stage     string
+	start     time.Time
+	files     int
+	bytes     int64
+	cacheHits int
+	steps     []stepRecord
+}
+
+func (p *buildProgress) init(stage string) {
+	p.stage = stage
+	p.start = time.Now()
+}
+
+func (p *buildProgress) setStage(stage string) {
+	p.lock.Lock()
+	p.stage = stage
+	p.lock.Unlock()
+}
+
+// setStep ends the running step and starts a new one.
+func (p *buildProgress) setStep(name, detail string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := time.Now()
+
+	p.endStep(now)
+
+	p.steps = append(p.steps, stepRecord{
+		ProgressStep: ProgressStep{
+			Stage:  p.stage,
+			Name:   name,
+			Detail: detail,
+		},
+		start: now,
+	})
+}
+
+func (p *buildProgress) endStep(now time.Time) {
+	if n := len(p.steps); n > 0 {
+		s := &p.steps[n-1]
+		s.Elapsed = int(now.Sub(s.start).Seconds())
+	}
+}
+
+func (p *buildProgress) addDownload(files int, bytes int64) {
+	p.lock.Lock()
+	p.files += files
+	p.bytes += bytes
+	p.lock.Unlock()
+}
+
+func (p *buildProgress) addCPIOFiles(files []filereceiver.CPIOFileMeta) {
+	var n int64
+	for i := range files {
+		n += files[i].Size
+	}
+
+	p.addDownload(len(files), n)
+}
+
+func (p *buildProgress) addCacheHits(n int) {
+	p.lock.Lock()
+	p.cacheHits += n
+	p.lock.Unlock()
+}
+
+func (p *buildProgress) get() Progress {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := time.Now()
+
+	p.endStep(now)
+
+	v := Progress{
+		Stage:     p.stage,
+		Files:     p.files,
+		Bytes:     p.bytes,
+		CacheHits: p.cacheHits,
+		Elapsed:   int(now.Sub(p.start).Seconds()),
+		Steps:     make([]ProgressStep, len(p.steps)),
+	}
+
+	for i := range p.steps {
+		v.Steps[i] = p.steps[i].ProgressStep
+	}
+
+	if n := len(p.steps); n > 0 {
+		v.Step = p.steps[n-1].Name
+		v.Detail = p.steps[n-1].Detail
+	}
+
+	return v
+}
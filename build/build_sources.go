@@ -21,8 +21,7 @@ func (b *buildSources) getSource() (string, error) {
 	info := b.getBuildInfo()
 
 	v, err := b.downloadPkgSource(
-		info.Project, info.Package, info.SrcMd5,
-		b.getSrcServer(), srcdir,
+		info.Project, info.Package, info.SrcMd5, srcdir,
 	)
 	if err != nil {
 		return "", fmt.Errorf("download pkg source failed, err: %s", err.Error())
@@ -78,7 +77,6 @@ func (b *buildSources) getBdeps(dir string) ([]string, error) {
 		return nil, nil
 	}
 
-	endpoint := b.getSrcServer()
 	items := info.getSrcBDep()
 	meta := make([]string, len(items))
 
@@ -89,8 +87,7 @@ func (b *buildSources) getBdeps(dir string) ([]string, error) {
 		}
 
 		_, err := b.downloadPkgSource(
-			item.Project, item.Package, item.SrcMd5,
-			endpoint, saveTo,
+			item.Project, item.Package, item.SrcMd5, saveTo,
 		)
 		if err != nil {
 			return nil, err
@@ -102,8 +99,8 @@ func (b *buildSources) getBdeps(dir string) ([]string, error) {
 	return meta, nil
 }
 
-func (b *buildSources) downloadPkgSource(project, pkg, srcmd5, endpoint, saveTo string) (
-	[]filereceiver.CPIOFileMeta, error,
+func (b *buildSources) downloadPkgSource(project, pkg, srcmd5, saveTo string) (
+	v []filereceiver.CPIOFileMeta, err error,
 ) {
 	opts := source.ListOpts{
 		Project: project,
@@ -117,12 +114,30 @@ func (b *buildSources) downloadPkgSource(project, pkg, srcmd5, endpoint, saveTo
 		return name, filepath.Join(saveTo, name), true, nil
 	}
 
-	return source.List(endpoint, &opts, check)
+	err = b.withFailover(
+		serverKindSource, fmt.Sprintf("sources of %s/%s", project, pkg),
+		b.getSrcServers(),
+		func(endpoint string) error {
+			v, err = source.List(b.ctx, endpoint, &opts, check)
+
+			return err
+		},
+	)
+	if err == nil {
+		b.progress.addCPIOFiles(v)
+	}
+
+	return
 }
 
 func (b *buildSources) downloadSSLCert() error {
-	return sslcert.List(
-		b.getSrcServer(), b.getBuildInfo().Project, true,
-		filepath.Join(b.getSrcdir(), "_projectcert.crt"),
+	return b.withFailover(
+		serverKindSource, "sslcert", b.getSrcServers(),
+		func(endpoint string) error {
+			return sslcert.List(
+				b.ctx, endpoint, b.getBuildInfo().Project, true,
+				filepath.Join(b.getSrcdir(), "_projectcert.crt"),
+			)
+		},
 	)
 }
@@ -4,8 +4,10 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/zengchen1024/obs-worker/metrics"
 	"github.com/zengchen1024/obs-worker/sdk/statistic"
 	"github.com/zengchen1024/obs-worker/utils"
 )
@@ -15,6 +17,9 @@ type buildStats struct {
 
 	startTime         int
 	downloadStartTime int
+
+	// lock protects the servers which are set by the parallel downloads.
+	lock sync.Mutex
 }
 
 func (s *buildStats) setPreInstallImage(img *preInstallImage) {
@@ -22,7 +27,9 @@ func (s *buildStats) setPreInstallImage(img *preInstallImage) {
 }
 
 func (s *buildStats) setCacheHit(n int) {
-	s.stats.Download.Cachehits = n
+	s.stats.Download.Cachehits += n
+
+	metrics.AddCacheHits(n)
 }
 
 func (s *buildStats) setBinaryDownloadDetail(num, size int) {
@@ -31,10 +38,28 @@ func (s *buildStats) setBinaryDownloadDetail(num, size int) {
 	}
 
 	s.stats.Download.Binaries += num
+
+	metrics.AddBinariesDownloaded(num)
+
 	s.stats.Download.Size.Unit = "k"
 	s.stats.Download.Size.Value += size
 }
 
+func (s *buildStats) setServer(kind, server string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	v := &s.stats.Download
+
+	for i := range v.Servers {
+		if item := &v.Servers[i]; item.Kind == kind && item.URL == server {
+			return
+		}
+	}
+
+	v.Servers = append(v.Servers, statistic.Server{Kind: kind, URL: server})
+}
+
 func (s *buildStats) recordDownloadStartTime() {
 	s.downloadStartTime = int(time.Now().Unix())
 }
@@ -2,12 +2,12 @@ package build
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strings"
-
-	"sigs.k8s.io/yaml"
 
+	"github.com/zengchen1024/obs-worker/metrics"
+	"github.com/zengchen1024/obs-worker/sdk/storable"
 	"github.com/zengchen1024/obs-worker/utils"
 )
 
@@ -40,10 +40,6 @@ type cacheBinInfo struct {
 	Size int    `json:"size"`
 }
 
-func (c *cacheBinInfo) toString() string {
-	return fmt.Sprintf("%s %d", c.Id, c.Size)
-}
-
 func (c *cacheBinInfo) getCacheFile(cacheDir string) string {
 	return genCacheFile(cacheDir, c.Id)
 }
@@ -56,58 +52,96 @@ func (c *cacheBinInfo) remove(cacheDir string) {
 
 type cacheManager struct {
 	*buildHelper
-
-	perlScriptDir    string
-	parseCacheScript string
-	setCacheScript   string
 }
 
 func (c *cacheManager) init(h *buildHelper) {
 	c.buildHelper = h
+}
 
-	dir := filepath.Join(h.workDir, "perl")
-
-	c.perlScriptDir = dir
-	c.parseCacheScript = filepath.Join(dir, "parse_cache_content.pm")
-	c.setCacheScript = filepath.Join(dir, "store_cache_content.pm")
+func (c *cacheManager) contentFile() string {
+	return filepath.Join(c.getCacheDir(), "content")
 }
 
+// getCurrentCacheInfo reads the content file which is the Storable
+// array of [id, size]. It is empty if the file is missing, and it fails
+// if the file is broken, so the cached files are not taken as orphans.
 func (c *cacheManager) getCurrentCacheInfo() ([]cacheBinInfo, error) {
-	cache := struct {
-		Content []cacheBinInfo `json:"content"`
-	}{}
-
-	v, err, _ := utils.RunCmd(
-		"perl",
-		"-I", c.perlScriptDir,
-		c.parseCacheScript, c.getCacheDir(),
-	)
+	f := c.contentFile()
+
+	b, err := ioutil.ReadFile(f)
 	if err != nil {
-		return nil, fmt.Errorf("%s, %v", v, err)
-	}
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
 
-	if err = yaml.Unmarshal(v, &cache); err != nil {
 		return nil, err
 	}
 
-	return cache.Content, nil
+	v, err := storable.Unmarshal(b)
+	if err != nil {
+		return nil, fmt.Errorf("parse cache content:%s, err:%s", f, err.Error())
+	}
+
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cache content:%s is not an array", f)
+	}
+
+	r := make([]cacheBinInfo, 0, len(items))
+	for _, item := range items {
+		if info, err := toCacheBinInfo(item); err != nil {
+			utils.LogErr("invalid item of cache content:%s, err:%s", f, err.Error())
+		} else {
+			r = append(r, info)
+		}
+	}
+
+	return r, nil
+}
+
+func toCacheBinInfo(v interface{}) (cacheBinInfo, error) {
+	item, ok := v.([]interface{})
+	if !ok || len(item) < 2 {
+		return cacheBinInfo{}, fmt.Errorf("not an array of id and size")
+	}
+
+	size, err := storable.Int(item[1])
+	if err != nil {
+		return cacheBinInfo{}, err
+	}
+
+	return cacheBinInfo{
+		Id:   storable.String(item[0]),
+		Size: int(size),
+	}, nil
 }
 
 func (c *cacheManager) setCacheInfo(cache []cacheBinInfo) error {
-	s := make([]string, 0, len(cache))
+	items := make([]interface{}, 0, len(cache))
 	for _, item := range cache {
 		if item.Size > 0 {
-			s = append(s, item.toString())
+			items = append(items, []interface{}{item.Id, item.Size})
 		}
 	}
 
-	v, err, _ := utils.RunCmd(
-		"perl",
-		"-I", c.perlScriptDir,
-		c.setCacheScript, c.getCacheDir(), strings.Join(s, "\n"),
-	)
+	b, err := storable.Marshal(items)
 	if err != nil {
-		return fmt.Errorf("%s, %v", v, err)
+		return err
+	}
+
+	dst := c.contentFile()
+	tmp := dst + ".new"
+
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		os.Remove(tmp)
+
+		return err
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+
+		return fmt.Errorf("rename %s to %s, err:%s", tmp, dst, err.Error())
 	}
 
 	return nil
@@ -163,14 +197,44 @@ func (c *cacheManager) addNewCache(caches []cacheBin) ([]cacheBinInfo, error) {
 	return r, nil
 }
 
-func (c *cacheManager) pruneCache(pruneSize int, oldCache []cacheBinInfo, news []cacheBin) error {
-	// if using content file itself as the lock file,
-	// the later actions will unlock it such as renaming which
-	// should be done in the context of locking.
-	cf, err := utils.LockOpen(
+// addMeta puts the meta file of the cached id into the cache. The cache is
+// locked, so the check doesn't remove the temporary file while writing it.
+func (c *cacheManager) addMeta(id, metaFile string) error {
+	cf, err := c.lock()
+	if err != nil {
+		return err
+	}
+
+	defer cf.Close()
+
+	cacheFile := genCacheFile(c.getCacheDir(), id) + ".meta"
+	tmp := cacheFile + cacheTmpSuffix
+
+	if linkOrCopy(metaFile, tmp) != nil {
+		return nil
+	}
+
+	if err := os.Rename(tmp, cacheFile); err != nil {
+		os.Remove(tmp)
+
+		return fmt.Errorf("rename %s -> %s failed, err: %s", tmp, cacheFile, err.Error())
+	}
+
+	return nil
+}
+
+// lock locks the cache. If using content file itself as the lock file,
+// the later actions will unlock it such as renaming which should be done
+// in the context of locking.
+func (c *cacheManager) lock() (utils.FileOp, error) {
+	return utils.LockOpen(
 		filepath.Join(c.getCacheDir(), "lock"),
 		os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644,
 	)
+}
+
+func (c *cacheManager) pruneCache(pruneSize int, oldCache []cacheBinInfo, news []cacheBin) error {
+	cf, err := c.lock()
 	if err != nil {
 		return err
 	}
@@ -187,17 +251,12 @@ func (c *cacheManager) pruneCache(pruneSize int, oldCache []cacheBinInfo, news [
 		return err
 	}
 
-	caches := make([]cacheBinInfo, 0, len(oldCache)+len(v)+len(v1))
-	caches = append(caches, v1...)
-	caches = append(caches, oldCache...)
-	caches = append(caches, v...)
+	caches := uniqueCaches(v1, oldCache, v)
 
-	var i int
-	for i = range caches {
-		pruneSize -= caches[i].Size
-		if pruneSize < 0 {
-			break
-		}
+	// keep the caches in order until the size exceeds.
+	size, i := 0, 0
+	for ; i < len(caches) && size+caches[i].Size <= pruneSize; i++ {
+		size += caches[i].Size
 	}
 
 	cacheDir := c.getCacheDir()
@@ -207,9 +266,12 @@ func (c *cacheManager) pruneCache(pruneSize int, oldCache []cacheBinInfo, news [
 		caches[j].remove(cacheDir)
 	}
 
+	metrics.SetCacheSize(int64(size))
+	metrics.AddCacheEvictions(n - i)
+
 	if err := c.setCacheInfo(caches[0:i]); err != nil {
 		for j := range v1 {
-			caches[j].remove(cacheDir)
+			v1[j].remove(cacheDir)
 		}
 
 		return err
@@ -217,3 +279,26 @@ func (c *cacheManager) pruneCache(pruneSize int, oldCache []cacheBinInfo, news [
 
 	return nil
 }
+
+// uniqueCaches joins the caches and keeps the first one of the same id,
+// otherwise the duplicate one will be evicted and its file is removed.
+func uniqueCaches(items ...[]cacheBinInfo) []cacheBinInfo {
+	n := 0
+	for i := range items {
+		n += len(items[i])
+	}
+
+	r := make([]cacheBinInfo, 0, n)
+	known := make(map[string]bool, n)
+
+	for i := range items {
+		for _, item := range items[i] {
+			if !known[item.Id] {
+				known[item.Id] = true
+				r = append(r, item)
+			}
+		}
+	}
+
+	return r
+}
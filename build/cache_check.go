@@ -0,0 +1,250 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zengchen1024/obs-worker/metrics"
+	"github.com/zengchen1024/obs-worker/sdk/filereceiver"
+	"github.com/zengchen1024/obs-worker/utils"
+)
+
+const cacheTmpSuffix = ".$$"
+
+type CacheCheckPolicy struct {
+	// OnStartup checks the cache before the slots start.
+	OnStartup bool `json:"on_startup"`
+
+	// VerifyRPM checks the hdrmd5 of cached rpms, it reads all of them.
+	VerifyRPM bool `json:"verify_rpm"`
+}
+
+// CacheCheckReport is what the check changed. The entries are reported
+// with the cache ids and the files with the paths relative to cache dir.
+type CacheCheckReport struct {
+	Entries int   `json:"entries"`
+	Size    int64 `json:"size"`
+
+	// Missing are the entries of content whose file is missing or empty.
+	Missing    []string `json:"missing,omitempty"`
+	Duplicates []string `json:"duplicates,omitempty"`
+	FixedSizes []string `json:"fixed_sizes,omitempty"`
+	Corrupted  []string `json:"corrupted,omitempty"`
+
+	// Orphans are the files which are not in the content.
+	Orphans   []string `json:"orphans,omitempty"`
+	TempFiles []string `json:"temp_files,omitempty"`
+}
+
+func (r *CacheCheckReport) changed() bool {
+	return len(r.Missing)+len(r.Duplicates)+len(r.FixedSizes)+len(r.Corrupted) > 0
+}
+
+func (r *CacheCheckReport) String() string {
+	return fmt.Sprintf(
+		"entries:%d, size:%d, missing:%d, duplicates:%d, fixed sizes:%d, "+
+			"corrupted:%d, orphans:%d, temp files:%d",
+		r.Entries, r.Size, len(r.Missing), len(r.Duplicates), len(r.FixedSizes),
+		len(r.Corrupted), len(r.Orphans), len(r.TempFiles),
+	)
+}
+
+// CheckCache reconciles the content of cache with the files under the
+// cache dir. The entries whose file is missing or corrupted are dropped,
+// the sizes are fixed, and the orphan and temporary files are removed.
+// The cache is locked during the check, so the builds wait to update it.
+func CheckCache(cfg *Config, verifyRPM bool) (CacheCheckReport, error) {
+	if cfg.CacheDir == "" {
+		return CacheCheckReport{}, fmt.Errorf("cache dir is not set")
+	}
+
+	c := cacheManager{buildHelper: &buildHelper{cfg: cfg}}
+
+	r, err := c.check(verifyRPM)
+	if err != nil {
+		return r, err
+	}
+
+	utils.LogInfo("checked cache:%s, %s", cfg.CacheDir, r.String())
+
+	return r, nil
+}
+
+func (c *cacheManager) check(verifyRPM bool) (r CacheCheckReport, err error) {
+	cf, err := c.lock()
+	if err != nil {
+		return
+	}
+
+	defer cf.Close()
+
+	cacheDir := c.getCacheDir()
+
+	// nothing is removed if the content can't be read.
+	caches, err := c.getCurrentCacheInfo()
+	if err != nil {
+		return
+	}
+
+	files, metas, err := c.scan(&r)
+	if err != nil {
+		return
+	}
+
+	kept := make([]cacheBinInfo, 0, len(caches))
+	known := make(map[string]bool, len(caches))
+
+	for _, item := range caches {
+		id := item.Id
+
+		if known[id] {
+			r.Duplicates = append(r.Duplicates, id)
+
+			continue
+		}
+
+		known[id] = true
+
+		size, ok := files[id]
+		if !ok || size == 0 {
+			r.Missing = append(r.Missing, id)
+			item.remove(cacheDir)
+
+			continue
+		}
+
+		if verifyRPM {
+			if err := checkCachedRPM(item.getCacheFile(cacheDir)); err != nil {
+				utils.LogErr("cached file:%s is corrupted, err:%s", id, err.Error())
+
+				r.Corrupted = append(r.Corrupted, id)
+				item.remove(cacheDir)
+
+				continue
+			}
+		}
+
+		if int64(item.Size) != size {
+			r.FixedSizes = append(r.FixedSizes, id)
+			item.Size = int(size)
+		}
+
+		kept = append(kept, item)
+
+		r.Entries++
+		r.Size += size
+	}
+
+	// the files of dropped entries have been removed.
+	for id := range files {
+		if !known[id] {
+			r.Orphans = append(r.Orphans, filepath.Join(id[:2], id))
+			os.Remove(genCacheFile(cacheDir, id))
+		}
+	}
+
+	for _, f := range metas {
+		if id := strings.TrimSuffix(filepath.Base(f), ".meta"); !known[id] {
+			r.Orphans = append(r.Orphans, f)
+			os.Remove(filepath.Join(cacheDir, f))
+		}
+	}
+
+	sort.Strings(r.Orphans)
+
+	metrics.SetCacheSize(r.Size)
+
+	if r.changed() {
+		err = c.setCacheInfo(kept)
+	}
+
+	return
+}
+
+// scan returns the cached files and the meta files in the cache dir,
+// the temporary files and the misplaced files are removed.
+func (c *cacheManager) scan(r *CacheCheckReport) (map[string]int64, []string, error) {
+	cacheDir := c.getCacheDir()
+
+	files := make(map[string]int64)
+	metas := []string{}
+
+	remove := func(rel string, items *[]string) {
+		if err := os.RemoveAll(filepath.Join(cacheDir, rel)); err != nil {
+			utils.LogErr("remove %s in cache, err:%s", rel, err.Error())
+		}
+
+		*items = append(*items, rel)
+	}
+
+	// it is left if crashed while writing the content.
+	if _, err := os.Lstat(c.contentFile() + ".new"); err == nil {
+		remove("content.new", &r.TempFiles)
+	}
+
+	dirs, err := os.ReadDir(cacheDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+
+		items, err := os.ReadDir(filepath.Join(cacheDir, dir.Name()))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, item := range items {
+			name := item.Name()
+			rel := filepath.Join(dir.Name(), name)
+
+			if strings.HasSuffix(name, cacheTmpSuffix) {
+				remove(rel, &r.TempFiles)
+
+				continue
+			}
+
+			id := strings.TrimSuffix(name, ".meta")
+			if !item.Type().IsRegular() || !strings.HasPrefix(id, dir.Name()) {
+				remove(rel, &r.Orphans)
+
+				continue
+			}
+
+			if id != name {
+				metas = append(metas, rel)
+
+				continue
+			}
+
+			info, err := item.Info()
+			if err != nil {
+				return nil, nil, err
+			}
+
+			files[id] = info.Size()
+		}
+	}
+
+	return files, metas, nil
+}
+
+// checkCachedRPM checks the hdrmd5 of file if it is a rpm.
+func checkCachedRPM(f string) error {
+	fo, err := os.Open(f)
+	if err != nil {
+		return err
+	}
+
+	defer fo.Close()
+
+	_, err = filereceiver.CheckHdrMD5(fo)
+
+	return err
+}
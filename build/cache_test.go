@@ -0,0 +1,331 @@
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zengchen1024/obs-worker/sdk/fakeobs"
+	"github.com/zengchen1024/obs-worker/sdk/storable"
+)
+
+func newTestCache(t *testing.T) *cacheManager {
+	return &cacheManager{
+		buildHelper: &buildHelper{cfg: &Config{CacheDir: t.TempDir()}},
+	}
+}
+
+// addTestCache writes the cached file of id with size bytes.
+func addTestCache(t *testing.T, c *cacheManager, id string, size int) cacheBinInfo {
+	item := cacheBinInfo{Id: id, Size: size}
+
+	f := item.getCacheFile(c.getCacheDir())
+	if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(f, []byte(strings.Repeat("x", size)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return item
+}
+
+func testCacheId(n int) string {
+	return genCacheId("prj/repo/x86_64", strings.Repeat(string(rune('a'+n)), 32))
+}
+
+func cacheIds(items []cacheBinInfo) []string {
+	r := make([]string, len(items))
+	for i := range items {
+		r[i] = items[i].Id
+	}
+
+	return r
+}
+
+func TestPruneCacheNothingToPrune(t *testing.T) {
+	c := newTestCache(t)
+
+	items := []cacheBinInfo{
+		addTestCache(t, c, testCacheId(0), 10),
+		addTestCache(t, c, testCacheId(1), 20),
+	}
+
+	if err := c.setCacheInfo(items); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.pruneCache(100, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := c.getCurrentCacheInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(v, items) {
+		t.Fatalf("got %v, want %v", v, items)
+	}
+
+	for i := range items {
+		if _, err := os.Stat(items[i].getCacheFile(c.getCacheDir())); err != nil {
+			t.Errorf("cached file of %s is removed", items[i].Id)
+		}
+	}
+}
+
+func TestPruneCacheEvictsInOrder(t *testing.T) {
+	c := newTestCache(t)
+
+	items := []cacheBinInfo{
+		addTestCache(t, c, testCacheId(0), 10),
+		addTestCache(t, c, testCacheId(1), 20),
+		addTestCache(t, c, testCacheId(2), 30),
+	}
+
+	if err := c.setCacheInfo(items); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.pruneCache(35, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := c.getCurrentCacheInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := items[:2]; !reflect.DeepEqual(v, want) {
+		t.Fatalf("got %v, want %v", cacheIds(v), cacheIds(want))
+	}
+
+	if _, err := os.Stat(items[2].getCacheFile(c.getCacheDir())); !os.IsNotExist(err) {
+		t.Errorf("cached file of the evicted one is kept")
+	}
+}
+
+func TestPruneCacheDedupe(t *testing.T) {
+	c := newTestCache(t)
+
+	a := addTestCache(t, c, testCacheId(0), 10)
+	b := addTestCache(t, c, testCacheId(1), 20)
+
+	if err := c.setCacheInfo([]cacheBinInfo{a, b}); err != nil {
+		t.Fatal(err)
+	}
+
+	// b is used by the build, so it is also in the old caches.
+	if err := c.pruneCache(100, []cacheBinInfo{b}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := c.getCurrentCacheInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []cacheBinInfo{b, a}; !reflect.DeepEqual(v, want) {
+		t.Fatalf("got %v, want %v", cacheIds(v), cacheIds(want))
+	}
+
+	if _, err := os.Stat(b.getCacheFile(c.getCacheDir())); err != nil {
+		t.Errorf("cached file of the duplicate one is removed")
+	}
+}
+
+// fakeRPM returns a rpm whose md5 in the signature header doesn't match
+// the data if corrupted.
+func fakeRPM(data string, corrupted bool) []byte {
+	r := fakeobs.RPM(data)
+	if corrupted {
+		r[len(r)-len(data)-16]++
+	}
+
+	return r
+}
+
+func writeTestFile(t *testing.T, f string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(f, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckCache(t *testing.T) {
+	c := newTestCache(t)
+	dir := c.getCacheDir()
+
+	fixed := addTestCache(t, c, testCacheId(0), 10)
+	missing := cacheBinInfo{Id: testCacheId(1), Size: 10}
+	corrupted := cacheBinInfo{Id: testCacheId(2), Size: 200}
+	good := cacheBinInfo{Id: testCacheId(3), Size: 200}
+	orphan := addTestCache(t, c, testCacheId(4), 10)
+
+	writeTestFile(t, corrupted.getCacheFile(dir), fakeRPM("corrupted", true))
+	writeTestFile(t, good.getCacheFile(dir), fakeRPM("good", false))
+	writeTestFile(t, good.getCacheFile(dir)+".meta", []byte("meta"))
+	writeTestFile(t, genCacheFile(dir, testCacheId(5))+".meta", []byte("meta"))
+	writeTestFile(t, fixed.getCacheFile(dir)+cacheTmpSuffix, []byte("tmp"))
+
+	content := []cacheBinInfo{
+		{Id: fixed.Id, Size: 5}, missing, corrupted, good, {Id: fixed.Id, Size: 5},
+	}
+	if err := c.setCacheInfo(content); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, c.contentFile()+".new", []byte("broken"))
+
+	r, err := c.check(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	goodSize := len(fakeRPM("good", false))
+
+	tmp := filepath.Join(fixed.Id[:2], fixed.Id+cacheTmpSuffix)
+	want := CacheCheckReport{
+		Entries:    2,
+		Size:       int64(10 + goodSize),
+		Missing:    []string{missing.Id},
+		Duplicates: []string{fixed.Id},
+		FixedSizes: []string{fixed.Id, good.Id},
+		Corrupted:  []string{corrupted.Id},
+		Orphans: []string{
+			filepath.Join(orphan.Id[:2], orphan.Id),
+			filepath.Join(testCacheId(5)[:2], testCacheId(5)+".meta"),
+		},
+		TempFiles: []string{"content.new", tmp},
+	}
+	sort.Strings(want.Orphans)
+
+	if !reflect.DeepEqual(r, want) {
+		t.Fatalf("got report:\n%+v\nwant:\n%+v", r, want)
+	}
+
+	v, err := c.getCurrentCacheInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixedContent := []cacheBinInfo{
+		{Id: fixed.Id, Size: 10}, {Id: good.Id, Size: goodSize},
+	}
+	if !reflect.DeepEqual(v, fixedContent) {
+		t.Fatalf("got content %+v, want %+v", v, fixedContent)
+	}
+
+	for _, f := range []string{
+		corrupted.getCacheFile(dir), orphan.getCacheFile(dir),
+		filepath.Join(dir, tmp), c.contentFile() + ".new",
+	} {
+		if _, err := os.Lstat(f); !os.IsNotExist(err) {
+			t.Errorf("%s is not removed", f)
+		}
+	}
+
+	if _, err := os.Stat(good.getCacheFile(dir) + ".meta"); err != nil {
+		t.Errorf("meta of the kept one is removed")
+	}
+
+	// the fixed cache is consistent, and pruning it keeps it so.
+	if err := c.pruneCache(1<<20, []cacheBinInfo{{Id: good.Id, Size: goodSize}}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = c.check(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (CacheCheckReport{Entries: 2, Size: int64(10 + goodSize)}); !reflect.DeepEqual(r, want) {
+		t.Fatalf("check again, got report:%+v", r)
+	}
+}
+
+func TestCheckCacheWithBrokenContent(t *testing.T) {
+	c := newTestCache(t)
+
+	item := addTestCache(t, c, testCacheId(0), 10)
+	meta := item.getCacheFile(c.getCacheDir()) + ".meta"
+	writeTestFile(t, meta, []byte("meta"))
+
+	// the content is not an array.
+	hash, err := storable.Marshal(map[string]interface{}{"id": item.Id})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, content := range [][]byte{[]byte("garbage"), hash} {
+		writeTestFile(t, c.contentFile(), content)
+
+		if _, err := c.check(false); err == nil {
+			t.Fatalf("check the cache with content:%q", content)
+		}
+
+		for _, f := range []string{item.getCacheFile(c.getCacheDir()), meta} {
+			if _, err := os.Stat(f); err != nil {
+				t.Fatalf("%s is removed with content:%q", f, content)
+			}
+		}
+	}
+}
+
+func TestAddMetaWaitsForCheck(t *testing.T) {
+	c := newTestCache(t)
+
+	item := addTestCache(t, c, testCacheId(0), 10)
+	if err := c.setCacheInfo([]cacheBinInfo{item}); err != nil {
+		t.Fatal(err)
+	}
+
+	meta := filepath.Join(t.TempDir(), "gcc.meta")
+	writeTestFile(t, meta, []byte("meta"))
+
+	// it is locked as the check does.
+	cf, err := c.lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- c.addMeta(item.Id, meta)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("add the meta when the cache is locked")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cf.Close()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c.check(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.Orphans)+len(r.TempFiles) != 0 {
+		t.Fatalf("got report:%+v", r)
+	}
+
+	if _, err := os.Stat(item.getCacheFile(c.getCacheDir()) + ".meta"); err != nil {
+		t.Fatal("the meta is not added")
+	}
+}
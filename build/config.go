@@ -91,6 +91,12 @@ type ObsBuild struct {
 	NoBuildUpdate  bool `json:"no_build_update"`
 }
 
+type Slot struct {
+	Id        string `json:"id" required:"true"`
+	BuildRoot string `json:"build_root" required:"true"`
+	StateDir  string `json:"state_dir" required:"true"`
+}
+
 type VM struct {
 	Openstack *Openstack `json:"openstack,omitempty"`
 
@@ -127,6 +133,36 @@ func (vm *VM) validate() error {
 	return nil
 }
 
+func (vm *VM) clone() *VM {
+	if vm == nil {
+		return nil
+	}
+
+	v := VM{}
+
+	if vm.Openstack != nil {
+		o := *vm.Openstack
+		v.Openstack = &o
+	}
+
+	if vm.Emulator != nil {
+		e := *vm.Emulator
+		v.Emulator = &e
+	}
+
+	if vm.OtherVM != nil {
+		o := *vm.OtherVM
+		v.OtherVM = &o
+	}
+
+	if vm.ZVM != nil {
+		z := *vm.ZVM
+		v.ZVM = &z
+	}
+
+	return &v
+}
+
 type Config struct {
 	Id    string `json:"id" required:"true"`
 	Owner string `json:"owner"`
@@ -144,13 +180,39 @@ type Config struct {
 	CacheDir  string `json:"cache_dir"`
 	CacheSize int    `json:"cache_size"`
 
+	// CacheCheck is the policy to check the cache for consistency.
+	CacheCheck CacheCheckPolicy `json:"cache_check"`
+
+	// MinFreeDisk is the free space in MB of the build root and
+	// cache dir below which the worker is not ready.
+	MinFreeDisk int `json:"min_free_disk"`
+
 	BinaryProxy string `json:"binary_proxy"`
 
+	// MaxFileSize is the biggest file in MB received from the servers.
+	MaxFileSize int `json:"max_file_size"`
+
+	// StatisticsServers reports the servers which the files are downloaded
+	// from in the _statistics of job. The backend must accept the
+	// <server> elements of download which are not in its schema.
+	StatisticsServers bool `json:"statistics_servers"`
+
+	// BinaryDownloadConcurrency is the number of the binary
+	// batches which are downloaded at the same time.
+	BinaryDownloadConcurrency int `json:"binary_download_concurrency"`
+
+	// ResultUpload is the policy to retry uploading the result of job.
+	ResultUpload ResultUploadPolicy `json:"result_upload"`
+
 	LocalKiwi      string `json:"local_kiwi"`
 	HardStatus     bool   `json:"hard_status"`
 	CleanupChroot  bool   `json:"cleanup_chroot"`
 	WipeAfterBuild bool   `json:"wipe_after_build"`
 
+	// Slots runs several build slots in one process. Each slot
+	// builds in its own build root and registers with its own id.
+	Slots []Slot `json:"slots"`
+
 	ObsBuild
 
 	*VM
@@ -169,6 +231,24 @@ func (c *Config) SetDefault() error {
 		c.Jobs = 1
 	}
 
+	if c.MinFreeDisk == 0 {
+		c.MinFreeDisk = 1024
+	}
+
+	if c.BinaryDownloadConcurrency == 0 {
+		c.BinaryDownloadConcurrency = 4
+	}
+
+	if c.MaxFileSize == 0 {
+		c.MaxFileSize = 16 << 10
+	}
+
+	c.ResultUpload.setDefault()
+
+	return nil
+}
+
+func (c *Config) setVMDefault() {
 	if info := c.GetVMInfo(); info != nil {
 		if info.Device == "" {
 			info.Device = c.BuildRoot + ".img"
@@ -178,6 +258,56 @@ func (c *Config) SetDefault() error {
 			info.Swap = c.BuildRoot + ".swap"
 		}
 	}
+}
+
+// SlotConfigs returns the config of each build slot.
+func (c *Config) SlotConfigs() []Config {
+	if len(c.Slots) == 0 {
+		v := *c
+		v.VM = c.VM.clone()
+		v.setVMDefault()
+
+		return []Config{v}
+	}
+
+	r := make([]Config, len(c.Slots))
+
+	for i := range c.Slots {
+		item := &c.Slots[i]
+
+		v := *c
+		v.Id = item.Id
+		v.BuildRoot = item.BuildRoot
+		v.StateDir = item.StateDir
+		v.Slots = nil
+		v.VM = c.VM.clone()
+		v.setVMDefault()
+
+		r[i] = v
+	}
+
+	return r
+}
+
+func (c *Config) validateSlots() error {
+	ids := sets.NewString()
+	dirs := sets.NewString()
+
+	for i := range c.Slots {
+		item := &c.Slots[i]
+
+		if ids.Has(item.Id) {
+			return fmt.Errorf("duplicate slot id:%s", item.Id)
+		}
+		ids.Insert(item.Id)
+
+		for _, d := range []string{item.BuildRoot, item.StateDir} {
+			if dirs.Has(d) {
+				return fmt.Errorf("slot:%s shares the directory:%s", item.Id, d)
+			}
+			dirs.Insert(d)
+		}
+	}
 
 	return nil
 }
@@ -194,10 +324,30 @@ func (c *Config) Validate() error {
 
 	c.CacheSize = c.CacheSize << 20
 
+	if c.CacheDir == "" && (c.CacheCheck.OnStartup || c.CacheCheck.VerifyRPM) {
+		return fmt.Errorf("cache check needs the cache dir")
+	}
+
+	if c.BinaryDownloadConcurrency < 0 {
+		return fmt.Errorf("binary download concurrency must not be negative")
+	}
+
+	if c.MaxFileSize < 0 {
+		return fmt.Errorf("max file size must not be negative")
+	}
+
+	if err := c.ResultUpload.validate(); err != nil {
+		return err
+	}
+
 	if err := c.VM.validate(); err != nil {
 		return err
 	}
 
+	if err := c.validateSlots(); err != nil {
+		return err
+	}
+
 	_, err := golangsdk.BuildRequestBody(c, "")
 	return err
 }
@@ -39,6 +39,8 @@ func (b *nonModeBinary) getBinaries(considerPreInstallImg bool) ([]string, error
 	if considerPreInstallImg {
 		utils.LogInfo("start getting preinstall image")
 
+		b.progress.setStep(BuildStepPreInstallImage, "")
+
 		imageBins, imagesWithMeta = b.getPreInstallImage(todo)
 
 		for k := range imageBins {
@@ -50,7 +52,10 @@ func (b *nonModeBinary) getBinaries(considerPreInstallImg bool) ([]string, error
 
 	utils.LogInfo("start getting binary cache")
 
-	done, metas := b.getBinaryCache(todo)
+	done, metas, err := b.getBinaryCache(todo)
+	if err != nil {
+		return nil, err
+	}
 
 	if todo.Len() > 0 {
 		return nil, fmt.Errorf(
@@ -88,53 +93,64 @@ func (b *nonModeBinary) getPreInstallImage(todo sets.String) (
 	return
 }
 
-func (b *nonModeBinary) getBinaryCache(bins sets.String) (map[string]string, sets.String) {
-	done := make(map[string]string)
+// getBinaryCache gets the binaries by the priority of repos. It fails if
+// any repo can't be listed, instead of getting from the next repo.
+func (b *nonModeBinary) getBinaryCache(bins sets.String) (map[string]binaryInfo, sets.String, error) {
+	done := make(map[string]binaryInfo)
 	imagesWithMeta := sets.NewString()
 
 	info := b.getBuildInfo()
+
+	repos := make([]*RepoPath, len(info.Paths))
 	for i := range info.Paths {
-		if bins.Len() == 0 {
-			break
-		}
+		repos[i] = &info.Paths[i]
+	}
 
-		repo := &info.Paths[i]
+	if bins.Len() > 0 && len(repos) > 0 {
+		b.progress.setStep(BuildStepBinaries, "")
 
-		got, err := b.binaryManager.get(
-			b.getPkgdir(), repo, bins.UnsortedList(),
-		)
+		got, err := b.binaryManager.getAll(b.getPkgdir(), repos, bins.List())
 		if err != nil {
-			utils.LogErr("get binary with cache, err: %s", err.Error())
+			return nil, nil, err
+		}
 
-			continue
+		for i := range repos {
+			b.handleBinaries(repos[i], got[i], bins, done, imagesWithMeta)
 		}
+	}
+
+	return done, imagesWithMeta, nil
+}
 
-		nometa := info.isRepoNoMeta(repo)
-		prpa := info.getPrpaOfRepo(repo)
+func (b *nonModeBinary) handleBinaries(
+	repo *RepoPath, got map[string]binaryInfo,
+	bins sets.String, done map[string]binaryInfo, imagesWithMeta sets.String,
+) {
+	info := b.getBuildInfo()
+	nometa := info.isRepoNoMeta(repo)
+	prpa := info.getPrpaOfRepo(repo)
 
-		for k, v := range got {
-			done[k] = v.name
-			bins.Delete(k)
+	for k, v := range got {
+		done[k] = v
+		bins.Delete(k)
 
-			if !nometa && v.hasMeta {
-				imagesWithMeta.Insert(k)
-			}
+		if !nometa && v.hasMeta {
+			imagesWithMeta.Insert(k)
+		}
 
-			if b.handleOutBDep != nil {
-				//TODO
-			}
+		if b.handleOutBDep != nil {
+			//TODO
+		}
 
-			if b.handleKiwiOrigin != nil {
-				b.handleKiwiOrigin(k, prpa)
-			}
+		if b.handleKiwiOrigin != nil {
+			b.handleKiwiOrigin(k, prpa)
 		}
 	}
-
-	return done, imagesWithMeta
 }
 
 func (b *nonModeBinary) genMetaData(
-	done, imageBins map[string]string,
+	done map[string]binaryInfo,
+	imageBins map[string]string,
 	imagesWithMeta sets.String,
 ) ([]string, error) {
 	info := b.getBuildInfo()
@@ -157,7 +173,11 @@ func (b *nonModeBinary) genMetaData(
 		}
 
 		if s, ok := done[bdep]; ok {
-			if v := queryHdrmd5(filepath.Join(dir, s)); v != "" {
+			if s.hdrmd5 != "" {
+				return s.hdrmd5
+			}
+
+			if v := queryHdrmd5(filepath.Join(dir, s.name)); v != "" {
 				return v
 			}
 		}
@@ -1,10 +1,14 @@
 package build
 
 import (
+	"context"
+	"fmt"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
+	"github.com/zengchen1024/obs-worker/metrics"
 	"github.com/zengchen1024/obs-worker/sdk/buildinfo"
 	"github.com/zengchen1024/obs-worker/sdk/job"
 	"github.com/zengchen1024/obs-worker/utils"
@@ -26,6 +30,7 @@ type nonModeBuid struct {
 	oldpkg        buildOldPackages
 
 	stage          string
+	stageStart     time.Time
 	needOBSPackage bool
 }
 
@@ -36,11 +41,16 @@ func newNonModeBuild(workDir string, cfg *Config, info *buildinfo.BuildInfo) (*n
 			info:    BuildInfo{BuildInfo: *info},
 			workDir: workDir,
 		},
-		stage: BuildStagePrepare,
+		stage:      BuildStagePrepare,
+		stageStart: time.Now(),
 	}
 
 	h := &b.buildHelper
 	h.init()
+	h.progress.init(b.stage)
+	if cfg.StatisticsServers {
+		h.handleServerUsed = b.stats.setServer
+	}
 
 	b.sources.buildHelper = h
 	b.rpmlist.buildHelper = h
@@ -91,6 +101,8 @@ func (b *nonModeBuid) preBuild() error {
 
 	info := b.getBuildInfo()
 	if !info.isNoUnchanged() && info.File != "preinstallimage" {
+		b.progress.setStep(BuildStepOldPackages, "")
+
 		if err := b.oldpkg.download(); err != nil {
 			return err
 		}
@@ -100,12 +112,16 @@ func (b *nonModeBuid) preBuild() error {
 
 	utils.LogInfo("start downloading project config")
 
+	b.progress.setStep(BuildStepProjectConfig, "")
+
 	if err := b.downloadProjectConfig(); err != nil {
 		return err
 	}
 
 	utils.LogInfo("start generating rpmlist")
 
+	b.progress.setStep(BuildStepRpmlist, "")
+
 	if err := b.rpmlist.generate(); err != nil {
 		return err
 	}
@@ -113,22 +129,40 @@ func (b *nonModeBuid) preBuild() error {
 	return nil
 }
 
+func (b *nonModeBuid) setStage(stage string) {
+	now := time.Now()
+
+	metrics.ObserveStage(b.stage, now.Sub(b.stageStart))
+
+	b.stage = stage
+	b.stageStart = now
+
+	b.progress.setStage(stage)
+}
+
 func (b *nonModeBuid) DoBuild(jobId string) (int, error) {
+	defer func() {
+		metrics.ObserveStage(b.stage, time.Since(b.stageStart))
+	}()
+
 	if err := b.preBuild(); err != nil {
-		return 0, err
+		return BuildCodeFailed, err
 	}
 
 	utils.LogInfo("start building")
 
-	b.stage = BuildStageBuilding
+	b.setStage(BuildStageBuilding)
+	b.progress.setStep(BuildStepBuild, "")
 
-	if c, err := b.build.do(); err != nil {
+	c, err := b.build.do()
+	if err != nil && c != BuildCodeUnchanged {
 		return c, err
 	}
 
 	utils.LogInfo("start post build")
 
-	b.stage = BuildStagePostBuild
+	b.setStage(BuildStagePostBuild)
+	b.progress.setStep(BuildStepPostBuild, "")
 
 	dir := b.env.otherDir
 
@@ -140,9 +174,7 @@ func (b *nonModeBuid) DoBuild(jobId string) (int, error) {
 
 	b.report.do(dir)
 
-	b.postBuild(jobId)
-
-	return 0, nil
+	return c, nil
 }
 
 func (b *nonModeBuid) setBuildInfoOut() {
@@ -167,6 +199,8 @@ func (b *nonModeBuid) setBuildInfoOut() {
 func (b *nonModeBuid) fetchSources() error {
 	utils.LogInfo("start getting sources")
 
+	b.progress.setStep(BuildStepSources, "")
+
 	s, err := b.sources.getSource()
 	if err != nil {
 		return err
@@ -179,6 +213,8 @@ func (b *nonModeBuid) fetchSources() error {
 	if needSSLCert {
 		utils.LogInfo("start downloading sslcert")
 
+		b.progress.setStep(BuildStepSSLCert, "")
+
 		if err := b.sources.downloadSSLCert(); err != nil {
 			return err
 		}
@@ -254,58 +290,106 @@ func (b *nonModeBuid) GetBuildStage() string {
 	return b.stage
 }
 
-func (b *nonModeBuid) SetSysrq()              {}
-func (b *nonModeBuid) AppenBuildLog(s string) {}
+func (b *nonModeBuid) GetProgress() Progress {
+	return b.progress.get()
+}
+
+func (b *nonModeBuid) SetSysrq() {}
+
+func (b *nonModeBuid) AppenBuildLog(s string) {
+	b.appendLog(s)
+}
+
 func (b *nonModeBuid) GetBuildLogFile() string {
 	return b.env.logFile
 }
 
-func (b *nonModeBuid) postBuild(jobId string) {
+func (b *nonModeBuid) PostBuild(jobId string, code int) error {
 	info := b.getBuildInfo()
 
 	opt := job.Opts{
 		Job:      info.Job,
 		Arch:     info.Arch,
 		JobId:    jobId,
-		Code:     "succeeded",
+		Code:     GenResultCode(code),
 		WorkerId: b.cfg.Id,
 	}
 
-	files := b.listBuildResultFiles()
-	if len(files) == 0 {
-		opt.Code = "failed"
+	files := []job.File{}
+
+	if code == BuildCodeSucceeded || code == BuildCodeUnchanged {
+		files, opt.KiwiTree = b.listBuildResultFiles()
+		if len(files) == 0 {
+			opt.Code = GenResultCode(BuildCodeFailed)
+		}
 	}
 
-	files = append(files,
-		job.File{
+	extra := []job.File{
+		{
 			Name: "meta",
 			Path: b.env.meta,
 		},
-		job.File{
+		{
 			Name: "logfile",
 			Path: b.env.logFile,
 		},
-	)
+	}
+	for _, f := range extra {
+		if f.Path != "" && isFileExist(f.Path) {
+			files = append(files, f)
+		}
+	}
 
-	err := job.Put(info.RepoServer, opt, files)
-	if err != nil {
-		utils.LogErr("upload build files, err:%s", err.Error())
+	// the result of a killed job is still uploaded, so don't use b.ctx.
+	if err := job.Put(context.Background(), info.RepoServer, opt, files); err != nil {
+		if IsResultRejected(err) {
+			return fmt.Errorf("upload build files, err:%s", err.Error())
+		}
+
+		if err1 := spoolResult(b.cfg, info.RepoServer, opt, files); err1 != nil {
+			return fmt.Errorf(
+				"upload build files, err:%s, and spool them, err:%s",
+				err.Error(), err1.Error(),
+			)
+		}
+
+		return fmt.Errorf("upload build files, err:%s, retry later", err.Error())
 	}
+
+	return nil
 }
 
-func (b *nonModeBuid) listBuildResultFiles() []job.File {
+// listBuildResultFiles returns the built files. The kiwi build marks its
+// result as a tree with .kiwitree, then the directories are uploaded too.
+func (b *nonModeBuid) listBuildResultFiles() ([]job.File, bool) {
 	dir := b.env.packages
 	dirs := lsDirs(filepath.Join(dir, "RPMS"))
-	dirs = append(
-		dirs,
-		filepath.Join(dir, "SRPMS"),
-		filepath.Join(dir, "OTHER"),
-	)
+	dirs = append(dirs, filepath.Join(dir, "SRPMS"))
+
+	kiwiMode := b.getBuildInfo().getkiwimode() != ""
+	if kiwiMode {
+		dirs = append(dirs, filepath.Join(dir, "KIWI"))
+	}
+
+	dirs = append(dirs, filepath.Join(dir, "OTHER"))
+
+	kiwiTree := false
+	for _, dir := range dirs {
+		if kiwiMode && isFileExist(filepath.Join(dir, ".kiwitree")) {
+			kiwiTree = true
+		}
+	}
 
 	r := []job.File{}
 
 	for _, dir := range dirs {
 		v := lsFiles(dir)
+		if kiwiTree {
+			for _, sub := range lsDirs(dir) {
+				v = append(v, filepath.Base(sub))
+			}
+		}
+
 		for _, name := range v {
 			if name != "same_result_marker" && name != ".kiwitree" {
 				r = append(r, job.File{
@@ -316,5 +400,5 @@ func (b *nonModeBuid) listBuildResultFiles() []job.File {
 		}
 	}
 
-	return r
+	return r, kiwiTree
 }
@@ -0,0 +1,401 @@
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/zengchen1024/obs-worker/sdk/buildinfo"
+	"github.com/zengchen1024/obs-worker/sdk/fakeobs"
+)
+
+// fakeBuildScript checks the rpmlist and the spec as obs-build does, then
+// writes the log, the args and a package built.
+const fakeBuildScript = `#!/bin/sh
+root=
+rpmlist=
+logfile=
+for arg in "$@"; do
+	case "$prev" in
+	--root) root=$arg ;;
+	--rpmlist) rpmlist=$arg ;;
+	--logfile) logfile=$arg ;;
+	esac
+	prev=$arg
+done
+spec=$arg
+
+echo "$@" > "$BUILD_DIR/../args"
+
+test -f "$spec" || { echo "no spec:$spec" >> "$logfile"; exit 1; }
+
+while read -r name file; do
+	case "$name" in
+	*:) ;;
+	*) test -f "$file" || { echo "missing $file" >> "$logfile"; exit 1; } ;;
+	esac
+done < "$rpmlist"
+
+echo "building $spec" >> "$logfile"
+mkdir -p "$root/.build.packages/RPMS/x86_64" "$root/.build.packages/SRPMS"
+echo "pkg" > "$root/.build.packages/RPMS/x86_64/pkg-1.0-1.x86_64.rpm"
+echo "src" > "$root/.build.packages/SRPMS/pkg-1.0-1.src.rpm"
+`
+
+// writeFixtures writes the fixtures of fakeobs for the package of
+// project prj, repository repo and arch x86_64, and returns its verifymd5.
+func writeFixtures(t *testing.T, dir string) string {
+	v, err := fakeobs.WritePackage(dir, &fakeobs.Package{
+		Project:    "prj",
+		Repository: "repo",
+		Arch:       "x86_64",
+		Name:       "pkg",
+		Sources: map[string]string{
+			"pkg.spec":   "Name: pkg\nVersion: 1.0\n",
+			"pkg.tar.gz": "source",
+		},
+		Config: "Type: spec\n",
+		Binaries: map[string][]byte{
+			"gcc.rpm":   fakeRPM("gcc", false),
+			"gcc.meta":  []byte("meta of gcc\n"),
+			"make.rpm":  fakeRPM("make", false),
+			"make.meta": []byte("meta of make\n"),
+		},
+		OldPackages: map[string][]byte{
+			"pkg-0.9-1.x86_64.rpm": []byte("old"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return v
+}
+
+func newTestBuild(t *testing.T, server string, verifyMd5 string) (*nonModeBuid, *Config) {
+	stateDir := t.TempDir()
+	writeTestFile(t, filepath.Join(stateDir, "build", "build"), []byte(fakeBuildScript))
+	if err := os.Chmod(filepath.Join(stateDir, "build", "build"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		Id:        "worker:1",
+		HostArch:  "x86_64",
+		StateDir:  stateDir,
+		BuildRoot: filepath.Join(t.TempDir(), "root"),
+		SrcServer: server,
+	}
+	if err := cfg.SetDefault(); err != nil {
+		t.Fatal(err)
+	}
+
+	bdep := func(name string) BDep {
+		return BDep{Name: name, Project: "prj", Repository: "repo", RepoArch: "x86_64", PreInstall: "1"}
+	}
+
+	info := &buildinfo.BuildInfo{
+		Project:    "prj",
+		Repository: "repo",
+		Package:    "pkg",
+		SrcServer:  server,
+		RepoServer: server,
+		Job:        "prj::repo::pkg-srcmd5",
+		Arch:       "x86_64",
+		SrcMd5:     "srcmd5",
+		VerifyMd5:  verifyMd5,
+		File:       "pkg.spec",
+		BDeps:      []BDep{bdep("gcc"), bdep("make")},
+		Paths:      []RepoPath{{Project: "prj", Repository: "repo", Server: server}},
+	}
+
+	b, err := newNonModeBuild(t.TempDir(), cfg, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b, cfg
+}
+
+// setTestCache sets the cache, the binaries are listed only if it is set.
+func setTestCache(t *testing.T, cfg *Config) {
+	cfg.CacheDir = t.TempDir()
+	cfg.CacheSize = 100 << 20
+}
+
+func TestNonModeBuild(t *testing.T) {
+	dir := t.TempDir()
+	verifyMd5 := writeFixtures(t, dir)
+
+	s, err := fakeobs.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	b, cfg := newTestBuild(t, s.URL, verifyMd5)
+
+	code, err := b.DoBuild("1")
+	if err != nil || code != BuildCodeSucceeded {
+		log, _ := ioutil.ReadFile(b.GetBuildLogFile())
+		t.Fatalf("build, code:%d, err:%v, log:%s", code, err, log)
+	}
+
+	// the binaries and old packages are downloaded.
+	for _, f := range []string{
+		filepath.Join(b.env.pkgdir, "gcc.rpm"),
+		filepath.Join(b.env.pkgdir, "make.rpm"),
+		filepath.Join(b.env.oldpkgdir, "pkg-0.9-1.x86_64.rpm"),
+	} {
+		if !isFileExist(f) {
+			t.Errorf("%s is not downloaded", f)
+		}
+	}
+
+	args, err := ioutil.ReadFile(filepath.Join(cfg.StateDir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(args), "--oldpackages "+b.env.oldpkgdir) {
+		t.Errorf("the old packages are not passed, args:%s", args)
+	}
+
+	meta, err := ioutil.ReadFile(b.env.meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(meta), genMetaLine(verifyMd5, "pkg")+"\n") ||
+		!strings.Contains(string(meta), "  gcc\n") || !strings.Contains(string(meta), "  make\n") {
+		t.Errorf("got meta:%s", meta)
+	}
+
+	if err := b.PostBuild("1", code); err != nil {
+		t.Fatal(err)
+	}
+
+	uploads := s.Uploads()
+	if len(uploads) != 1 {
+		t.Fatalf("got %d uploads", len(uploads))
+	}
+
+	v := uploads[0]
+	if v.Job != "prj::repo::pkg-srcmd5" || v.JobId != "1" || v.Code != "succeeded" || v.WorkerId != "worker:1" {
+		t.Errorf("got upload:%+v", v)
+	}
+
+	names := []string{}
+	for i := range v.Files {
+		names = append(names, v.Files[i].Name)
+	}
+	sort.Strings(names)
+
+	want := []string{
+		"_buildenv", "_statistics", "logfile", "meta",
+		"pkg-1.0-1.src.rpm", "pkg-1.0-1.x86_64.rpm",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got uploaded files:%v", names)
+	}
+}
+
+func TestNonModeBuildMissingBinary(t *testing.T) {
+	dir := t.TempDir()
+	verifyMd5 := writeFixtures(t, dir)
+
+	if err := os.Remove(filepath.Join(dir, "repos", "prj", "repo", "x86_64", "make.rpm")); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := fakeobs.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	b, _ := newTestBuild(t, s.URL, verifyMd5)
+
+	code, err := b.DoBuild("1")
+	if err == nil || code != BuildCodeFailed || !strings.Contains(err.Error(), "make") {
+		t.Fatalf("expect the missing make, code:%d, err:%v", code, err)
+	}
+
+	if err := b.PostBuild("1", code); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := s.Uploads(); len(v) != 1 || v[0].Code != "failed" {
+		t.Fatalf("got uploads:%+v", v)
+	}
+}
+
+func TestNonModeBuildListingFails(t *testing.T) {
+	dir := t.TempDir()
+	verifyMd5 := writeFixtures(t, dir)
+
+	s, err := fakeobs.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.SetStatus("/getbinaryversions", 500)
+
+	b, cfg := newTestBuild(t, s.URL, verifyMd5)
+	setTestCache(t, cfg)
+
+	// the job fails instead of looking for the binaries in the next path.
+	if code, err := b.DoBuild("1"); err == nil || code != BuildCodeFailed {
+		t.Fatalf("expect failure, code:%d, err:%v", code, err)
+	}
+
+	n := 0
+	for _, v := range s.Requests() {
+		if strings.HasPrefix(v, "/getbinaries?") {
+			t.Fatalf("get binaries without listing them:%s", v)
+		}
+
+		if strings.HasPrefix(v, "/getbinaryversions?") {
+			n++
+		}
+	}
+
+	// the listing is retried, besides the one of the preinstall image.
+	if n < maxBinaryRetries+1 {
+		t.Fatalf("the listing is requested %d times", n)
+	}
+}
+
+func TestNonModeBuildDownloadFailover(t *testing.T) {
+	dir := t.TempDir()
+	verifyMd5 := writeFixtures(t, dir)
+
+	s, err := fakeobs.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s1, err := fakeobs.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s1.Close()
+
+	s.SetStatus("/getbinaries", 500)
+
+	b, cfg := newTestBuild(t, s.URL, verifyMd5)
+	setTestCache(t, cfg)
+
+	// the next path has the binaries too.
+	info := b.getBuildInfo()
+	info.Paths = append(info.Paths, RepoPath{Project: "prj", Repository: "repo", Server: s1.URL})
+
+	code, err := b.DoBuild("1")
+	if err != nil || code != BuildCodeSucceeded {
+		log, _ := ioutil.ReadFile(b.GetBuildLogFile())
+		t.Fatalf("build, code:%d, err:%v, log:%s", code, err, log)
+	}
+
+	for _, f := range []string{"gcc.rpm", "make.rpm"} {
+		if !isFileExist(filepath.Join(b.env.pkgdir, f)) {
+			t.Errorf("%s is not downloaded", f)
+		}
+	}
+
+	count := func(s *fakeobs.Server) (n int) {
+		for _, v := range s.Requests() {
+			if strings.HasPrefix(v, "/getbinaries?") {
+				n++
+			}
+		}
+
+		return
+	}
+
+	// the failed batch is retried on the first path, then got from the next one.
+	if n := count(s); n != maxBinaryRetries+1 {
+		t.Errorf("get binaries from the first path %d times", n)
+	}
+
+	if n := count(s1); n != 1 {
+		t.Errorf("get binaries from the next path %d times", n)
+	}
+}
+
+func TestNonModeBuildDownloadFails(t *testing.T) {
+	dir := t.TempDir()
+	verifyMd5 := writeFixtures(t, dir)
+
+	s, err := fakeobs.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.SetStatus("/getbinaries", 500)
+
+	b, cfg := newTestBuild(t, s.URL, verifyMd5)
+	setTestCache(t, cfg)
+
+	// the failure of download is reported instead of the missing packages.
+	code, err := b.DoBuild("1")
+	if err == nil || code != BuildCodeFailed || !strings.Contains(err.Error(), "download binaries:gcc, make") {
+		t.Fatalf("expect the failed download, code:%d, err:%v", code, err)
+	}
+}
+
+func TestPostBuildKiwiTree(t *testing.T) {
+	s, err := fakeobs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	b, cfg := newTestBuild(t, s.URL, "")
+	if err := b.env.init(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	kiwi := filepath.Join(b.env.packages, "KIWI")
+	writeTestFile(t, filepath.Join(kiwi, ".kiwitree"), nil)
+	writeTestFile(t, filepath.Join(kiwi, "image.packages"), []byte("packages"))
+	writeTestFile(t, filepath.Join(kiwi, "image", "boot", "grub.cfg"), []byte("grub"))
+	if err := os.Symlink("boot/grub.cfg", filepath.Join(kiwi, "image", "grub.cfg")); err != nil {
+		t.Fatal(err)
+	}
+
+	// it is not a kiwi build, so the KIWI dir is not uploaded.
+	if files, kiwiTree := b.listBuildResultFiles(); len(files) != 0 || kiwiTree {
+		t.Fatalf("got files:%v, kiwitree:%v", files, kiwiTree)
+	}
+
+	b.getBuildInfo().File = "image.kiwi"
+
+	if err := b.PostBuild("1", BuildCodeSucceeded); err != nil {
+		t.Fatal(err)
+	}
+
+	uploads := s.Uploads()
+	if len(uploads) != 1 || !uploads[0].KiwiTree || uploads[0].Code != "succeeded" {
+		t.Fatalf("got uploads:%+v", uploads)
+	}
+
+	names := []string{}
+	for _, v := range uploads[0].Files {
+		names = append(names, v.Name)
+	}
+	sort.Strings(names)
+
+	want := []string{
+		"image", "image.packages", "image/boot", "image/boot/grub.cfg", "image/grub.cfg", "logfile",
+	}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("got uploaded files:%v", names)
+	}
+}
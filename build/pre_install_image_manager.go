@@ -1,11 +1,13 @@
 package build
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"strconv"
 
 	"github.com/zengchen1024/obs-worker/sdk/binary"
+	"github.com/zengchen1024/obs-worker/sdk/filereceiver"
 	"github.com/zengchen1024/obs-worker/sdk/image"
 	"github.com/zengchen1024/obs-worker/utils"
 	"k8s.io/apimachinery/pkg/util/sets"
@@ -143,11 +145,25 @@ func (h *preInstallImageManager) getBinary(repo *RepoPath, bins []string) (
 		NoMeta: info.isRepoNoMeta(repo),
 	}
 
-	endpoint := info.getRepoServer(repo)
+	var v binary.BinaryVersionList
+	var used string
 
-	v, err := binary.List(endpoint, &opts)
+	err := h.withFailover(
+		serverKindRepo, "binary versions of "+info.getPrpaOfRepo(repo),
+		h.getRepoServers(info.getRepoServer(repo)),
+		func(endpoint string) (err error) {
+			proxy, server := h.getBinaryServer(endpoint)
+			opts.Server = server
 
-	return v, endpoint, err
+			if v, err = binary.List(h.ctx, proxy, &opts); err == nil {
+				used = endpoint
+			}
+
+			return
+		},
+	)
+
+	return v, used, err
 }
 
 func (h *preInstallImageManager) getImagesFromRepo(
@@ -167,11 +183,11 @@ func (h *preInstallImageManager) getImagesFromRepo(
 
 	for endpoint, prpa := range prpas {
 		v, err := image.Post(
-			endpoint,
+			h.ctx, endpoint,
 			&image.QueryOpts{
 				Prpa: prpa,
 			},
-			match, h.workDir,
+			match,
 		)
 		if err != nil {
 			utils.LogErr("getpreinstallimageinfos, err: %s", err.Error())
@@ -329,7 +345,7 @@ func (h *preInstallImageManager) downloadImage(
 	ifile := img.getImageFilePath(h.getPkgdir())
 	os.Remove(ifile)
 
-	if err := img.download(ifile); err != nil {
+	if err := img.download(h.ctx, ifile); err != nil {
 		return false
 	}
 
@@ -340,6 +356,8 @@ func (h *preInstallImageManager) downloadImage(
 		return false
 	}
 
+	h.progress.addDownload(1, v.Size())
+
 	// manage_cache
 	data := img.genCacheMeta()
 	tmp := ifile + ".meta"
@@ -432,7 +450,9 @@ func (h *preInstallImageManager) downloadImageMeta(
 	prpa := h.getBuildInfo().getPrpa()
 	dir := h.getPkgdir()
 
-	for _, name := range res {
+	for i := range res {
+		name := res[i].Name
+
 		bin, ok := isMetaFile(name)
 		if !ok {
 			continue
@@ -446,14 +466,8 @@ func (h *preInstallImageManager) downloadImageMeta(
 		}
 
 		metaFile := filepath.Join(dir, name)
-		v, err := utils.GenMd5OfFile(metaFile)
-		if err != nil {
-			utils.LogErr("gen md5 of file: %s, err: %s", metaFile, err.Error())
-
-			continue
-		}
 
-		if v != bv.MetaMD5 {
+		if res[i].MD5 != bv.MetaMD5 {
 			os.Remove(metaFile)
 
 			continue
@@ -462,17 +476,10 @@ func (h *preInstallImageManager) downloadImageMeta(
 		todo.Delete(bin)
 
 		if cacheDir != "" {
-			cacheFile := genCacheFile(cacheDir, genCacheId(prpa, bv.HdrMD5))
-			tmp := cacheFile + ".meta.$$"
-			if nil == linkOrCopy(metaFile, tmp) {
-				if nil != os.Rename(tmp, cacheFile+".meta") {
-					utils.LogErr(
-						"rename %s -> %s failed, err: %s",
-						tmp, cacheFile+".meta", err.Error(),
-					)
-
-					return false
-				}
+			if err := h.cache.addMeta(genCacheId(prpa, bv.HdrMD5), metaFile); err != nil {
+				utils.LogErr(err.Error())
+
+				return false
 			}
 		}
 	}
@@ -480,8 +487,9 @@ func (h *preInstallImageManager) downloadImageMeta(
 	return todo.Len() == 0
 }
 
-func (h *preInstallImageManager) downloadBinaries(todo sets.String) ([]string, error) {
+func (h *preInstallImageManager) downloadBinaries(todo sets.String) ([]filereceiver.CPIOFileMeta, error) {
 	info := h.getBuildInfo()
+
 	opts := binary.DownloadOpts{
 		CommonOpts: binary.CommonOpts{
 			WorkerId:   h.getWorkerId(),
@@ -492,20 +500,27 @@ func (h *preInstallImageManager) downloadBinaries(todo sets.String) ([]string, e
 		},
 	}
 
-	res, err := binary.Download(
-		info.fetchRepoServer(), &opts, h.getPkgdir(),
-	)
+	var res []filereceiver.CPIOFileMeta
+
+	err := h.withFailover(
+		serverKindRepo, "binaries of "+info.getPrpa(),
+		h.getRepoServers(info.fetchRepoServer()),
+		func(repoServer string) (err error) {
+			endpoint, server := h.getBinaryServer(repoServer)
+			opts.Server = server
 
+			res, err = binary.Download(h.ctx, endpoint, &opts, h.getPkgdir())
+
+			return
+		},
+	)
 	if err != nil {
 		return nil, err
 	}
 
-	v := make([]string, len(res))
-	for i := range res {
-		v[i] = res[i].Name
-	}
+	h.progress.addCPIOFiles(res)
 
-	return v, nil
+	return res, nil
 }
 
 type imageInfo struct {
@@ -525,6 +540,6 @@ func (b *imageInfo) getImageFilePath(dir string) string {
 	return filepath.Join(dir, getImageFile(b.img))
 }
 
-func (b *imageInfo) download(saveTo string) error {
-	return image.Download(b.loadFrom, b.img.Prpa, b.img.Path, saveTo)
+func (b *imageInfo) download(ctx context.Context, saveTo string) error {
+	return image.Download(ctx, b.loadFrom, b.img.Prpa, b.img.Path, saveTo, b.img.MD5)
 }
@@ -0,0 +1,250 @@
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/zengchen1024/obs-worker/sdk/job"
+	"github.com/zengchen1024/obs-worker/utils"
+)
+
+const (
+	resultUploadDir  = "result"
+	resultUploadFile = "upload.json"
+)
+
+type ResultUploadPolicy struct {
+	// Backoff and MaxBackoff are the seconds to wait between the retries.
+	Backoff    int `json:"backoff"`
+	MaxBackoff int `json:"max_backoff"`
+
+	// MaxAttempts is unlimited if it is 0.
+	MaxAttempts int `json:"max_attempts"`
+
+	// MaxAge is the hours after which the result is abandoned.
+	MaxAge int `json:"max_age"`
+}
+
+func (p *ResultUploadPolicy) setDefault() {
+	if p.Backoff == 0 {
+		p.Backoff = 10
+	}
+
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = 600
+	}
+
+	if p.MaxAge == 0 {
+		p.MaxAge = 24
+	}
+}
+
+func (p *ResultUploadPolicy) validate() error {
+	if p.Backoff < 0 || p.MaxBackoff < 0 || p.MaxAttempts < 0 || p.MaxAge < 0 {
+		return fmt.Errorf("negative value of result upload policy")
+	}
+
+	if p.Backoff > p.MaxBackoff {
+		return fmt.Errorf("backoff of result upload is bigger than max backoff")
+	}
+
+	return nil
+}
+
+func (p *ResultUploadPolicy) Wait(attempts int) time.Duration {
+	v := time.Duration(p.Backoff) * time.Second
+	if attempts > 1 {
+		v <<= uint(attempts - 1)
+	}
+
+	if m := time.Duration(p.MaxBackoff) * time.Second; v > m || v <= 0 {
+		v = m
+	}
+
+	return v
+}
+
+// Abandoned returns the reason if the upload should not be retried anymore.
+func (p *ResultUploadPolicy) Abandoned(u *ResultUpload) string {
+	if p.MaxAttempts > 0 && u.Attempts >= p.MaxAttempts {
+		return fmt.Sprintf("tried %d times", u.Attempts)
+	}
+
+	age := time.Since(time.Unix(u.Created, 0))
+	if age > time.Duration(p.MaxAge)*time.Hour {
+		return fmt.Sprintf("it is spooled for %s", age.Truncate(time.Second))
+	}
+
+	return ""
+}
+
+// ResultUpload is the result of job which failed to be uploaded. It is
+// spooled in the state dir, so the upload can be retried even after the
+// worker restarts.
+type ResultUpload struct {
+	Server   string     `json:"server"`
+	Opts     job.Opts   `json:"opts"`
+	KiwiTree bool       `json:"kiwitree,omitempty"`
+	Files    []job.File `json:"files"`
+	Created  int64      `json:"created"`
+	Attempts int        `json:"attempts"`
+
+	dir string
+}
+
+func (u *ResultUpload) JobId() string {
+	return u.Opts.JobId
+}
+
+func (u *ResultUpload) Upload(ctx context.Context) error {
+	opts := u.Opts
+	opts.KiwiTree = u.KiwiTree
+
+	return job.Put(ctx, u.Server, opts, u.Files)
+}
+
+func (u *ResultUpload) Save() error {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+
+	f := filepath.Join(u.dir, resultUploadFile)
+	tmp := f + ".tmp"
+
+	if err := utils.WriteFile(tmp, b); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, f)
+}
+
+func (u *ResultUpload) Remove() error {
+	return os.RemoveAll(u.dir)
+}
+
+// LoadResultUpload returns the spooled result, or nil if there is not.
+func LoadResultUpload(cfg *Config) (*ResultUpload, error) {
+	dir := filepath.Join(cfg.StateDir, resultUploadDir)
+
+	b, err := os.ReadFile(filepath.Join(dir, resultUploadFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// the worker may exit when spooling.
+			return nil, os.RemoveAll(dir)
+		}
+
+		return nil, err
+	}
+
+	u := &ResultUpload{dir: dir}
+	if err := json.Unmarshal(b, u); err != nil {
+		return nil, fmt.Errorf("invalid spooled result, err:%s", err.Error())
+	}
+
+	return u, nil
+}
+
+func spoolResult(cfg *Config, server string, opts job.Opts, files []job.File) (err error) {
+	dir := filepath.Join(cfg.StateDir, resultUploadDir)
+
+	if err = os.RemoveAll(dir); err != nil {
+		return
+	}
+
+	if err = mkdirAll(filepath.Join(dir, "files")); err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	u := ResultUpload{
+		Server:   server,
+		Opts:     opts,
+		KiwiTree: opts.KiwiTree,
+		Files:    make([]job.File, len(files)),
+		Created:  time.Now().Unix(),
+		Attempts: 1,
+		dir:      dir,
+	}
+
+	for i := range files {
+		f := &files[i]
+
+		// the build root is cleaned by the next job, so keep a copy.
+		dst := filepath.Join(dir, "files", strconv.Itoa(i))
+		if err = linkOrCopyTree(f.Path, dst); err != nil {
+			return
+		}
+
+		u.Files[i] = job.File{Name: f.Name, Path: dst}
+	}
+
+	return u.Save()
+}
+
+// linkOrCopyTree keeps the directory tree with the symlinks in it.
+func linkOrCopyTree(src, dst string) error {
+	if v, err := os.Stat(src); err != nil || !v.IsDir() {
+		return linkOrCopy(src, dst)
+	}
+
+	root, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		to := filepath.Join(dst, rel)
+
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			return os.MkdirAll(to, 0755)
+
+		case mode&os.ModeSymlink != 0:
+			v, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+
+			return os.Symlink(v, to)
+
+		case mode.IsRegular():
+			return linkOrCopy(p, to)
+		}
+
+		return nil
+	})
+}
+
+// IsResultRejected returns true if the repo server refuses the result,
+// such as the job is stale. It is useless to upload it again.
+func IsResultRejected(err error) bool {
+	var e *utils.StatusError
+	if !errors.As(err, &e) {
+		return false
+	}
+
+	return e.Code >= 400 && e.Code < 500 &&
+		e.Code != http.StatusRequestTimeout && e.Code != http.StatusTooManyRequests
+}
@@ -0,0 +1,139 @@
+package build
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zengchen1024/obs-worker/utils"
+)
+
+const (
+	serverKindSource = "source"
+	serverKindRepo   = "repo"
+
+	// a failed server is tried after the others within this time.
+	serverDownPeriod = time.Minute
+)
+
+// serverHealth is shared by all the jobs, so a server failed
+// by one job will be tried last by the others for a while.
+var serverHealth = &serversHealth{down: make(map[string]time.Time)}
+
+type serversHealth struct {
+	lock sync.Mutex
+	down map[string]time.Time
+}
+
+func (h *serversHealth) setDown(server string) {
+	h.lock.Lock()
+	h.down[server] = time.Now().Add(serverDownPeriod)
+	h.lock.Unlock()
+}
+
+func (h *serversHealth) setUp(server string) {
+	h.lock.Lock()
+	delete(h.down, server)
+	h.lock.Unlock()
+}
+
+// sort puts the servers which are down at the end and keeps the order of others.
+func (h *serversHealth) sort(servers []string) []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	now := time.Now()
+	up := make([]string, 0, len(servers))
+	down := []string{}
+
+	for _, s := range servers {
+		if t, ok := h.down[s]; ok && now.Before(t) {
+			down = append(down, s)
+		} else {
+			up = append(up, s)
+		}
+	}
+
+	return append(up, down...)
+}
+
+func uniqServers(servers ...string) []string {
+	r := make([]string, 0, len(servers))
+	seen := make(map[string]bool)
+
+	for _, s := range servers {
+		if s != "" && !seen[s] {
+			seen[s] = true
+			r = append(r, s)
+		}
+	}
+
+	return r
+}
+
+// getSrcServers returns the source servers in the order of preference.
+func (h *buildHelper) getSrcServers() []string {
+	return uniqServers(h.info.SrcServer, h.cfg.SrcServer)
+}
+
+// getRepoServers returns the repo servers in the order of preference.
+// The primary is the first one, then the one of job and the configured.
+func (h *buildHelper) getRepoServers(primary string) []string {
+	v := []string{primary, h.info.RepoServer}
+
+	return uniqServers(append(v, h.cfg.RepoServers...)...)
+}
+
+// withFailover calls do with the servers one by one until it succeeds.
+// It only tries the next server if the failure is caused by the server.
+func (h *buildHelper) withFailover(kind, what string, servers []string, do func(string) error) error {
+	if len(servers) == 0 {
+		return fmt.Errorf("no %s server to get %s", kind, what)
+	}
+
+	var err error
+
+	sorted := serverHealth.sort(servers)
+
+	for i, s := range sorted {
+		if i > 0 {
+			h.appendLog(fmt.Sprintf(
+				"%s server %s failed to get %s, try %s\n", kind, sorted[i-1], what, s,
+			))
+		}
+
+		if err = do(s); err == nil {
+			serverHealth.setUp(s)
+
+			if h.handleServerUsed != nil {
+				h.handleServerUsed(kind, s)
+			}
+
+			if s != servers[0] {
+				h.appendLog(fmt.Sprintf("got %s from %s server %s\n", what, kind, s))
+			}
+
+			return nil
+		}
+
+		if h.isCancel() || !utils.IsServerError(err) {
+			return err
+		}
+
+		utils.LogErr("get %s from %s, err:%s", what, s, err.Error())
+
+		serverHealth.setDown(s)
+	}
+
+	return err
+}
+
+func (h *buildHelper) appendLog(s string) {
+	if h.env.logFile == "" {
+		return
+	}
+
+	if err := appendFile(h.env.logFile, s); err != nil {
+		utils.LogErr("append build log, err:%s", err.Error())
+	}
+}
@@ -137,6 +137,21 @@ func readFileLineByLine(filename string, handle func(string) bool) error {
 	return nil
 }
 
+func appendFile(f, s string) error {
+	fo, err := os.OpenFile(f, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = fo.WriteString(s)
+
+	if err1 := fo.Close(); err == nil {
+		err = err1
+	}
+
+	return err
+}
+
 func mkdir(dir string) error {
 	return os.Mkdir(dir, os.FileMode(0777))
 }
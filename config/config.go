@@ -1,6 +1,10 @@
 package config
 
-import "github.com/zengchen1024/obs-worker/build"
+import (
+	"github.com/zengchen1024/obs-worker/build"
+	"github.com/zengchen1024/obs-worker/controllers"
+	"github.com/zengchen1024/obs-worker/utils"
+)
 
 func Load(path string) (*Config, error) {
 	v := new(Config)
@@ -18,13 +22,37 @@ func Load(path string) (*Config, error) {
 }
 
 type Config struct {
-	Build build.Config `json:"build"`
+	Build     build.Config             `json:"build"`
+	Access    controllers.AccessConfig `json:"access"`
+	TLS       utils.ServerTLSConfig    `json:"tls"`
+	ClientTLS utils.ClientTLSConfig    `json:"client_tls"`
+
+	HTTPClient utils.HTTPClientConfig `json:"http_client"`
 }
 
 func (c *Config) setDefault() error {
+	c.Access.SetDefault()
+	c.HTTPClient.SetDefault()
+
 	return c.Build.SetDefault()
 }
 
 func (c *Config) validate() error {
+	if err := c.Access.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.TLS.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.ClientTLS.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.HTTPClient.Validate(); err != nil {
+		return err
+	}
+
 	return c.Build.Validate()
 }
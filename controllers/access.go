@@ -0,0 +1,322 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zengchen1024/obs-worker/utils"
+)
+
+const defaultTokenHeader = "X-OBS-Worker-Token"
+
+type AccessPolicy struct {
+	// AllowedAddrs is the list of IPs, CIDRs, host names or URLs of the
+	// clients which can access. Any client can access if it is empty
+	// and AllowRepoServers is false.
+	AllowedAddrs []string `json:"allowed_addrs"`
+
+	// AllowRepoServers adds the repo servers of the worker to AllowedAddrs.
+	AllowRepoServers bool `json:"allow_repo_servers"`
+
+	// Token must be sent in the token header if it is set.
+	Token string `json:"token"`
+}
+
+type AccessConfig struct {
+	TokenHeader string `json:"token_header"`
+
+	// ResolveInterval is the seconds after which the host names
+	// of allowed addresses are resolved again.
+	ResolveInterval int `json:"resolve_interval"`
+
+	// Read is the policy of the read-only endpoints, such as /info,
+	// /worker and /logfile.
+	Read AccessPolicy `json:"read"`
+
+	// Write is the policy of the endpoints which change the job,
+	// such as /build, /kill and /discard.
+	Write AccessPolicy `json:"write"`
+}
+
+func (c *AccessConfig) SetDefault() {
+	if c.TokenHeader == "" {
+		c.TokenHeader = defaultTokenHeader
+	}
+
+	if c.ResolveInterval == 0 {
+		c.ResolveInterval = 60
+	}
+}
+
+func (c *AccessConfig) Validate() error {
+	if c.ResolveInterval < 0 {
+		return fmt.Errorf("resolve interval must not be negative")
+	}
+
+	for _, p := range []*AccessPolicy{&c.Read, &c.Write} {
+		for _, s := range p.AllowedAddrs {
+			if strings.Contains(s, "/") && !strings.Contains(s, "://") {
+				if _, _, err := net.ParseCIDR(s); err != nil {
+					return fmt.Errorf("invalid cidr:%s, err:%s", s, err.Error())
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+type accessPolicy struct {
+	// restricted is true if the addresses are set, then the client is
+	// rejected unless it matches one of them, even if none is resolved.
+	restricted bool
+
+	nets  []*net.IPNet
+	hosts *hostResolver
+	token string
+}
+
+func newAccessPolicy(p *AccessPolicy, repoServers []string, interval time.Duration) accessPolicy {
+	addrs := p.AllowedAddrs
+	if p.AllowRepoServers {
+		addrs = append(append([]string{}, addrs...), repoServers...)
+	}
+
+	r := accessPolicy{
+		restricted: len(p.AllowedAddrs) > 0 || p.AllowRepoServers,
+		token:      p.Token,
+	}
+
+	names := []string{}
+
+	for _, s := range addrs {
+		host, err := hostOfAddr(s)
+		if err != nil {
+			utils.LogErr("parse allowed address:%s, err:%s", s, err.Error())
+
+			continue
+		}
+
+		if v := parseIPNet(host); v != nil {
+			r.nets = append(r.nets, v)
+		} else {
+			names = append(names, host)
+		}
+	}
+
+	if len(names) > 0 {
+		r.hosts = newHostResolver(names, interval)
+	}
+
+	return r
+}
+
+func hostOfAddr(s string) (string, error) {
+	if !strings.Contains(s, "://") {
+		return s, nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("no host")
+	}
+
+	return u.Hostname(), nil
+}
+
+// parseIPNet returns nil if s is neither a CIDR nor an IP.
+func parseIPNet(s string) *net.IPNet {
+	if _, n, err := net.ParseCIDR(s); err == nil {
+		return n
+	}
+
+	if ip := net.ParseIP(s); ip != nil {
+		return toIPNet(ip)
+	}
+
+	return nil
+}
+
+func toIPNet(ip net.IP) *net.IPNet {
+	bits := 128
+	if ip.To4() != nil {
+		ip = ip.To4()
+		bits = 32
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
+
+// hostResolver resolves the host names again after the interval, so the
+// changed addresses of them are allowed without restarting. The last
+// addresses of a name are kept if it fails to resolve.
+type hostResolver struct {
+	names    []string
+	interval time.Duration
+	lookup   func(string) ([]net.IP, error)
+
+	lock   sync.Mutex
+	nets   map[string][]*net.IPNet
+	expiry time.Time
+}
+
+func newHostResolver(names []string, interval time.Duration) *hostResolver {
+	h := &hostResolver{
+		names:    names,
+		interval: interval,
+		lookup:   net.LookupIP,
+		nets:     make(map[string][]*net.IPNet),
+	}
+
+	h.get()
+
+	return h
+}
+
+func (h *hostResolver) get() []*net.IPNet {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if now := time.Now(); now.After(h.expiry) {
+		h.resolve()
+		h.expiry = now.Add(h.interval)
+	}
+
+	r := []*net.IPNet{}
+	for _, v := range h.nets {
+		r = append(r, v...)
+	}
+
+	return r
+}
+
+func (h *hostResolver) resolve() {
+	for _, name := range h.names {
+		ips, err := h.lookup(name)
+		if err != nil {
+			utils.LogErr("resolve allowed host:%s, err:%s", name, err.Error())
+
+			continue
+		}
+
+		v := make([]*net.IPNet, len(ips))
+		for i := range ips {
+			v[i] = toIPNet(ips[i])
+		}
+
+		h.nets[name] = v
+	}
+}
+
+func (p *accessPolicy) checkAddr(remoteAddr string) error {
+	if !p.restricted {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid client address")
+	}
+
+	if containsIP(p.nets, ip) {
+		return nil
+	}
+
+	if p.hosts != nil && containsIP(p.hosts.get(), ip) {
+		return nil
+	}
+
+	return fmt.Errorf("client address is not allowed")
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *accessPolicy) checkToken(token string) error {
+	if p.token == "" {
+		return nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(p.token), []byte(token)) != 1 {
+		return fmt.Errorf("invalid token")
+	}
+
+	return nil
+}
+
+// AccessControl checks the clients of the endpoints.
+type AccessControl struct {
+	baseController
+
+	tokenHeader string
+	read        accessPolicy
+	write       accessPolicy
+}
+
+func NewAccessControl(cfg *AccessConfig, repoServers []string) *AccessControl {
+	interval := time.Duration(cfg.ResolveInterval) * time.Second
+
+	return &AccessControl{
+		tokenHeader: cfg.TokenHeader,
+		read:        newAccessPolicy(&cfg.Read, repoServers, interval),
+		write:       newAccessPolicy(&cfg.Write, repoServers, interval),
+	}
+}
+
+// Read wraps the handler of a read-only endpoint.
+func (a *AccessControl) Read(h http.HandlerFunc) http.HandlerFunc {
+	return a.wrap(&a.read, h)
+}
+
+// Write wraps the handler of an endpoint which changes the job.
+func (a *AccessControl) Write(h http.HandlerFunc) http.HandlerFunc {
+	return a.wrap(&a.write, h)
+}
+
+func (a *AccessControl) wrap(p *accessPolicy, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := p.checkAddr(r.RemoteAddr)
+		if err == nil {
+			err = p.checkToken(r.Header.Get(a.tokenHeader))
+		}
+
+		if err == nil {
+			h(w, r)
+
+			return
+		}
+
+		utils.LogErr(
+			"reject request: %s %s from %s, err:%s",
+			r.Method, r.URL.Path, r.RemoteAddr, err.Error(),
+		)
+
+		if strings.HasPrefix(r.URL.Path, APIPrefix+"/") {
+			APIController{}.replyJSON(w, http.StatusForbidden, apiError{Error: err.Error()})
+		} else {
+			a.replyMsg(w, http.StatusForbidden, err.Error())
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAccessFailClosed(t *testing.T) {
+	p := newAccessPolicy(&AccessPolicy{AllowRepoServers: true}, nil, time.Minute)
+
+	if err := p.checkAddr("10.0.0.1:1234"); err == nil {
+		t.Fatal("allowed when no address is set but the repo servers are required")
+	}
+
+	p = newAccessPolicy(&AccessPolicy{}, nil, time.Minute)
+	if err := p.checkAddr("10.0.0.1:1234"); err != nil {
+		t.Fatalf("rejected when no address is required, err:%v", err)
+	}
+
+	p = newAccessPolicy(
+		&AccessPolicy{AllowedAddrs: []string{"10.0.0.0/24", "http://10.0.1.1:5252"}},
+		nil, time.Minute,
+	)
+
+	for addr, ok := range map[string]bool{
+		"10.0.0.9:1": true, "10.0.1.1:1": true, "10.0.1.2:1": false, "bad": false,
+	} {
+		if err := p.checkAddr(addr); (err == nil) != ok {
+			t.Errorf("check %s, got err:%v", addr, err)
+		}
+	}
+}
+
+func TestAccessResolveAgain(t *testing.T) {
+	ips := map[string]string{"repo": "10.0.0.1"}
+	failed := false
+
+	h := &hostResolver{
+		names:    []string{"repo"},
+		interval: time.Hour,
+		nets:     make(map[string][]*net.IPNet),
+		lookup: func(name string) ([]net.IP, error) {
+			if failed {
+				return nil, fmt.Errorf("lookup failed")
+			}
+
+			return []net.IP{net.ParseIP(ips[name])}, nil
+		},
+	}
+
+	p := accessPolicy{restricted: true, hosts: h}
+
+	if err := p.checkAddr("10.0.0.1:1"); err != nil {
+		t.Fatalf("rejected the resolved host, err:%v", err)
+	}
+
+	// the address of host is changed.
+	ips["repo"] = "10.0.0.2"
+
+	if err := p.checkAddr("10.0.0.2:1"); err == nil {
+		t.Fatal("resolved again before the interval")
+	}
+
+	h.expiry = time.Time{}
+
+	if err := p.checkAddr("10.0.0.2:1"); err != nil {
+		t.Fatalf("rejected the new address of host, err:%v", err)
+	}
+
+	if err := p.checkAddr("10.0.0.1:1"); err == nil {
+		t.Fatal("allowed the old address of host")
+	}
+
+	// the last addresses are kept if failed to resolve.
+	failed = true
+	h.expiry = time.Time{}
+
+	if err := p.checkAddr("10.0.0.2:1"); err != nil {
+		t.Fatalf("rejected the last address of host, err:%v", err)
+	}
+}
+
+func TestDrainIsPostOnly(t *testing.T) {
+	c := BuildController{}
+
+	for _, h := range []http.HandlerFunc{c.Drain, c.Undrain} {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodGet, "/drain", nil))
+
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("got code:%d of GET", w.Code)
+		}
+	}
+}
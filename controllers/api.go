@@ -0,0 +1,280 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/zengchen1024/obs-worker/sdk/buildinfo"
+	"github.com/zengchen1024/obs-worker/sdk/worker"
+	"github.com/zengchen1024/obs-worker/sdk/workerstate"
+	"github.com/zengchen1024/obs-worker/utils"
+	wm "github.com/zengchen1024/obs-worker/worker"
+)
+
+const APIPrefix = "/api/v1"
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type apiResult struct {
+	Result string `json:"result"`
+}
+
+type apiJob struct {
+	JobId      string `json:"job_id"`
+	State      string `json:"state"`
+	Job        string `json:"job,omitempty"`
+	Project    string `json:"project,omitempty"`
+	Repository string `json:"repository,omitempty"`
+	Package    string `json:"package,omitempty"`
+	Arch       string `json:"arch,omitempty"`
+	SrcMd5     string `json:"srcmd5,omitempty"`
+	VerifyMd5  string `json:"verifymd5,omitempty"`
+	File       string `json:"file,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	RepoServer string `json:"repo_server,omitempty"`
+	SrcServer  string `json:"src_server,omitempty"`
+	BDeps      int    `json:"bdeps"`
+}
+
+func newAPIJob(state *workerstate.WorkerState, info *buildinfo.BuildInfo) apiJob {
+	return apiJob{
+		JobId:      state.JobId,
+		State:      state.State,
+		Job:        info.Job,
+		Project:    info.Project,
+		Repository: info.Repository,
+		Package:    info.Package,
+		Arch:       info.Arch,
+		SrcMd5:     info.SrcMd5,
+		VerifyMd5:  info.VerifyMd5,
+		File:       info.File,
+		Reason:     info.Reason,
+		RepoServer: info.RepoServer,
+		SrcServer:  info.SrcServer,
+		BDeps:      len(info.BDeps),
+	}
+}
+
+type apiWorker struct {
+	WorkerId string `json:"worker_id"`
+	HostArch string `json:"host_arch"`
+	Port     int    `json:"port"`
+	State    string `json:"state"`
+	JobId    string `json:"job_id,omitempty"`
+}
+
+func newAPIWorker(w *worker.Worker, state *workerstate.WorkerState) apiWorker {
+	v := apiWorker{
+		WorkerId: w.Workerid,
+		HostArch: w.Hostarch,
+		Port:     w.Port,
+		State:    state.State,
+	}
+
+	if state.State != workerstate.WorkerStateIdle {
+		v.JobId = state.JobId
+	}
+
+	return v
+}
+
+type apiLog struct {
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	Mtime int64  `json:"mtime"`
+}
+
+// APIController serves the JSON API. It shares the BuildManager
+// methods with the XML endpoints.
+type APIController struct {
+	BuildController
+}
+
+func (a APIController) replyJSON(w http.ResponseWriter, code int, v interface{}) {
+	data, err := utils.JsonMarshal(v)
+	if err != nil {
+		code = http.StatusInternalServerError
+		data = []byte(`{"error":"marshal response failed"}`)
+	}
+
+	a.setCommonHeader(w, "application/json")
+
+	w.WriteHeader(code)
+
+	if err := utils.Write(nil, w, data); err != nil {
+		utils.LogErr("write response, err:%s", err.Error())
+	}
+}
+
+func (a APIController) replyError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+
+	switch {
+	case errors.Is(err, wm.ErrNotBuilding):
+		code = http.StatusConflict
+
+	case errors.Is(err, wm.ErrOtherJob):
+		code = http.StatusNotFound
+
+	case errors.Is(err, wm.ErrNoCache):
+		code = http.StatusNotFound
+
+	case errors.Is(err, wm.ErrSlotRequired):
+		code = http.StatusBadRequest
+	}
+
+	a.replyJSON(w, code, apiError{Error: err.Error()})
+}
+
+func (a APIController) checkMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+
+	w.Header().Set("Allow", method)
+	a.replyJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method is not allowed"})
+
+	return false
+}
+
+func (a APIController) Job(w http.ResponseWriter, r *http.Request) {
+	if !a.checkMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	info, state, err := a.buildManager(r).GetJobSnapshot(a.jobid(r))
+	if err != nil {
+		a.replyError(w, err)
+
+		return
+	}
+
+	a.replyJSON(w, http.StatusOK, newAPIJob(&state, &info))
+}
+
+func (a APIController) Progress(w http.ResponseWriter, r *http.Request) {
+	if !a.checkMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	v, err := a.buildManager(r).GetProgress(a.jobid(r))
+	if err != nil {
+		a.replyError(w, err)
+
+		return
+	}
+
+	a.replyJSON(w, http.StatusOK, v)
+}
+
+// Worker replies all the slots if neither jobid nor workerid is specified.
+func (a APIController) Worker(w http.ResponseWriter, r *http.Request) {
+	if !a.checkMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	if a.jobid(r) == "" && a.workerid(r) == "" {
+		a.workers(w)
+
+		return
+	}
+
+	bm, err := wm.FindBuildManager(a.jobid(r), a.workerid(r))
+	if err != nil {
+		a.replyError(w, err)
+
+		return
+	}
+
+	v, state, err := bm.GetWorkerSnapshot(a.jobid(r))
+	if err != nil {
+		a.replyError(w, err)
+
+		return
+	}
+
+	a.replyJSON(w, http.StatusOK, newAPIWorker(&v, &state))
+}
+
+func (a APIController) workers(w http.ResponseWriter) {
+	slots := wm.GetBuildManagers()
+
+	r := make([]apiWorker, 0, len(slots))
+	for _, bm := range slots {
+		v, state, err := bm.GetWorkerSnapshot("")
+		if err != nil {
+			a.replyError(w, err)
+
+			return
+		}
+
+		r = append(r, newAPIWorker(&v, &state))
+	}
+
+	a.replyJSON(w, http.StatusOK, r)
+}
+
+func (a APIController) KillJob(w http.ResponseWriter, r *http.Request) {
+	a.operate(w, r, (*wm.BuildManager).KillJob)
+}
+
+func (a APIController) DiscardJob(w http.ResponseWriter, r *http.Request) {
+	a.operate(w, r, (*wm.BuildManager).DiscardJob)
+}
+
+func (a APIController) SetBadHostJob(w http.ResponseWriter, r *http.Request) {
+	a.operate(w, r, (*wm.BuildManager).SetBadHostJob)
+}
+
+func (a APIController) operate(
+	w http.ResponseWriter, r *http.Request,
+	op func(*wm.BuildManager, string) error,
+) {
+	if !a.checkMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	if err := op(a.buildManager(r), a.jobid(r)); err != nil {
+		a.replyError(w, err)
+
+		return
+	}
+
+	a.replyJSON(w, http.StatusOK, apiResult{Result: "ok"})
+}
+
+func (a APIController) Log(w http.ResponseWriter, r *http.Request) {
+	if !a.checkMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	var v apiLog
+
+	err := a.buildManager(r).GetBuildLog(
+		a.jobid(r),
+		func(file string, _ <-chan struct{}) error {
+			info, err := os.Stat(file)
+			if err != nil {
+				return err
+			}
+
+			v = apiLog{
+				Name:  "_log",
+				Size:  info.Size(),
+				Mtime: info.ModTime().Unix(),
+			}
+
+			return nil
+		},
+	)
+	if err != nil {
+		a.replyError(w, err)
+
+		return
+	}
+
+	a.replyJSON(w, http.StatusOK, v)
+}
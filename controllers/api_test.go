@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zengchen1024/obs-worker/build"
+	"github.com/zengchen1024/obs-worker/sdk/fakeobs"
+	"github.com/zengchen1024/obs-worker/worker"
+)
+
+func TestAPIGolden(t *testing.T) {
+	a := APIController{}
+
+	checkGolden(t, "api_method", rawResponse(t, a.Job, http.MethodPost))
+
+	checkGolden(t, "api_error", rawResponse(t, func(w http.ResponseWriter, r *http.Request) {
+		a.replyError(w, worker.ErrOtherJob)
+	}, http.MethodGet))
+
+	checkGolden(t, "api_result", rawResponse(t, func(w http.ResponseWriter, r *http.Request) {
+		a.replyJSON(w, http.StatusOK, apiResult{Result: "ok"})
+	}, http.MethodGet))
+}
+
+func TestAPIJob(t *testing.T) {
+	s, err := fakeobs.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	cfg := &build.Config{
+		Id:          "worker:1",
+		HostArch:    "x86_64",
+		StateDir:    t.TempDir(),
+		BuildRoot:   filepath.Join(t.TempDir(), "root"),
+		RepoServers: []string{s.URL},
+	}
+	if err := cfg.SetDefault(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := worker.Init(cfg, 8080); err != nil {
+		t.Fatal(err)
+	}
+
+	a := APIController{}
+
+	cases := []struct {
+		query string
+		code  int
+		body  string
+	}{
+		{"", http.StatusOK, `{"job_id":"","state":"idle","bdeps":0}`},
+		{"?jobid=1", http.StatusConflict, `{"error":"` + worker.ErrNotBuilding.Error() + `"}`},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		a.Job(w, httptest.NewRequest(http.MethodGet, "/job"+c.query, nil))
+
+		if w.Code != c.code || strings.TrimSpace(w.Body.String()) != c.body {
+			t.Errorf("query:%q, got code:%d, body:%s", c.query, w.Code, w.Body.String())
+		}
+	}
+}
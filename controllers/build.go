@@ -1,18 +1,16 @@
 package controllers
 
 import (
-	"fmt"
+	"errors"
+	"io/ioutil"
 	"net/http"
 	"strconv"
-	"strings"
 
 	"github.com/zengchen1024/obs-worker/sdk/opstatus"
 	"github.com/zengchen1024/obs-worker/utils"
 	"github.com/zengchen1024/obs-worker/worker"
 )
 
-const separator = "\r\n"
-
 type respData interface {
 	Marshal() ([]byte, error)
 }
@@ -31,39 +29,32 @@ func (c baseController) replyMsg(w http.ResponseWriter, code int, s string) {
 }
 
 func (c baseController) reply(w http.ResponseWriter, code int, r respData) {
-	header := []string{""}
-
 	if code == 0 || (code >= 200 && code < 300) {
 		code = 200
-		header[0] = "HTTP/1.1 200 OK"
-	} else {
-		header[0] = fmt.Sprintf("HTTP/1.1 %d Error", code)
 	}
 
-	header = append(
-		header,
-		"Content-Type: text/xml",
-		"Cache-Control: no-cache",
-		"Connection: close",
-	)
-
 	var data []byte
 	if r != nil {
 		if v, err := r.Marshal(); err == nil {
-			header = append(
-				header,
-				fmt.Sprintf("Content-Length: %d", len(v)),
-			)
-
 			data = v
 		}
 	}
 
-	header = append(header, separator)
+	c.setCommonHeader(w, "text/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
 
 	w.WriteHeader(code)
 
-	fmt.Fprint(w, strings.Join(header, separator), data)
+	if err := utils.Write(nil, w, data); err != nil {
+		utils.LogErr("write response, err:%s", err.Error())
+	}
+}
+
+func (c baseController) setCommonHeader(w http.ResponseWriter, contentType string) {
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "close")
 }
 
 type BuildController struct {
@@ -108,10 +99,15 @@ func (b BuildController) Build(w http.ResponseWriter, r *http.Request) {
 
 	job.Id = jobId
 
-	if err = job.Create(q.Get("registerserver")); err != nil {
+	if err = job.Create(q.Get("registerserver"), q.Get("workerid")); err != nil {
 		utils.LogErr("create job failed, err:%s", err.Error())
 
-		b.replyMsg(w, 500, err.Error())
+		code := 500
+		if errors.Is(err, worker.ErrDraining) || errors.Is(err, worker.ErrUploading) {
+			code = 503
+		}
+
+		b.replyMsg(w, code, err.Error())
 
 		return
 	}
@@ -131,17 +127,15 @@ func (b BuildController) extract(
 ) (string, error) {
 	defer r.Body.Close()
 
-	if v := r.Header.Get("expect"); strings.ToLower(v) == "100-continue" {
-		fmt.Fprint(w, "HTTP/1.1 100 continue\r\n\r\n")
-	}
+	// net/http replies "100 Continue" itself when the body is read.
+	var data []byte
+	var err error
 
-	v := r.Header.Get("Content-Length")
-	n, err := strconv.Atoi(v)
-	if err != nil {
-		return "", err
+	if n := r.ContentLength; n >= 0 {
+		data, err = utils.ReadData(r.Body, int(n))
+	} else {
+		data, err = ioutil.ReadAll(r.Body)
 	}
-
-	data, err := utils.ReadData(r.Body, n)
 	if err != nil {
 		return "", err
 	}
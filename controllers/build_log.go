@@ -6,15 +6,16 @@ import (
 	"net/http"
 	"os"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/zengchen1024/obs-worker/sdk/directory"
 	"github.com/zengchen1024/obs-worker/utils"
-	"github.com/zengchen1024/obs-worker/worker"
 )
 
+const logFollowInterval = time.Second
+
 func (b BuildController) GetBuildLog(w http.ResponseWriter, r *http.Request) {
-	callback := func(file string) error {
+	callback := func(file string, done <-chan struct{}) error {
 		q := r.URL.Query()
 
 		if q.Get("view") == "entry" {
@@ -42,10 +43,16 @@ func (b BuildController) GetBuildLog(w http.ResponseWriter, r *http.Request) {
 			end = &j
 		}
 
-		return b.uploadLog(file, int64(start), end, w)
+		// same as OBS, the log is streamed until the job ends
+		// unless nostream is set or a range is requested.
+		if end == nil && q.Get("nostream") == "" && r.Header.Get("Range") == "" {
+			return b.followLog(file, int64(start), done, w, r)
+		}
+
+		return b.uploadLog(file, int64(start), end, w, r)
 	}
 
-	err := worker.GetBuildManager().GetBuildLog(b.jobid(r), callback)
+	err := b.buildManager(r).GetBuildLog(b.jobid(r), callback)
 	if err != nil {
 		b.replyMsg(w, 400, err.Error())
 	}
@@ -72,17 +79,10 @@ func (b BuildController) loginfo(file string, w http.ResponseWriter) error {
 	return nil
 }
 
-func (b BuildController) uploadLog(file string, start int64, end *int64, w http.ResponseWriter) error {
-	total := int64(0)
-	if end != nil {
-		if total = *end - start; total <= 0 {
-			return fmt.Errorf("end is smaller than start")
-		}
-	}
-
+func (b BuildController) openLog(file string, start int64) (*os.File, os.FileInfo, error) {
 	info, err := os.Stat(file)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	v := start
@@ -90,83 +90,136 @@ func (b BuildController) uploadLog(file string, start int64, end *int64, w http.
 		v = -v
 	}
 	if info.Size() < v {
-		return fmt.Errorf("log file is not that big")
+		return nil, nil, fmt.Errorf("log file is not that big")
 	}
 
 	f, err := os.Open(file)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	defer f.Close()
-
 	whence := os.SEEK_SET
 	if start < 0 {
 		whence = os.SEEK_END
 	}
 
-	pos, err := f.Seek(start, whence)
+	if _, err := f.Seek(start, whence); err != nil {
+		f.Close()
+
+		return nil, nil, err
+	}
+
+	return f, info, nil
+}
+
+// uploadLog sends the part of log selected by start and end. The HTTP
+// Range and conditional headers apply to that part.
+func (b BuildController) uploadLog(
+	file string, start int64, end *int64,
+	w http.ResponseWriter, r *http.Request,
+) error {
+	total := int64(0)
+	if end != nil {
+		if total = *end - start; total <= 0 {
+			return fmt.Errorf("end is smaller than start")
+		}
+	}
+
+	f, info, err := b.openLog(file, start)
 	if err != nil {
 		return err
 	}
 
-	w.WriteHeader(200)
+	defer f.Close()
 
-	header := []string{
-		"HTTP/1.1 200 OK",
-		"Content-Type: text/plain",
-		"Transfer-Encoding: chunked",
-		"Cache-Control: no-cache",
-		"Connection: close",
-		separator,
+	pos, err := f.Seek(0, os.SEEK_CUR)
+	if err != nil {
+		return err
 	}
-	fmt.Fprint(w, strings.Join(header, separator))
 
 	if v := info.Size() - pos; total == 0 || total > v {
 		total = v
 	}
 
-	upload(f, total, w)
+	etag := fmt.Sprintf("%x-%x-%x", info.ModTime().UnixNano(), pos, total)
+
+	b.setCommonHeader(w, "text/plain")
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	// a range of the compressed data can't be resumed reliably,
+	// so only the whole content is compressed.
+	if r.Header.Get("Range") == "" && acceptGzip(r) {
+		gw := newGzipResponseWriter(w)
+		defer gw.Close()
+
+		w = gw
+		etag += "-gzip"
+	}
+
+	w.Header().Set("ETag", strconv.Quote(etag))
+
+	http.ServeContent(w, r, "", info.ModTime(), io.NewSectionReader(f, pos, total))
 
 	return nil
 }
 
-func upload(r io.Reader, total int64, w io.Writer) {
-	read := func() ([]byte, error) {
-		if total == 0 {
-			return nil, nil
-		}
+func (b BuildController) followLog(
+	file string, start int64, done <-chan struct{},
+	w http.ResponseWriter, r *http.Request,
+) error {
+	f, _, err := b.openLog(file, start)
+	if err != nil {
+		return err
+	}
 
-		n := 4096
-		buf := make([]byte, n+8)
+	defer f.Close()
 
-		if total < int64(n) {
-			n = int(total)
-		}
+	b.setCommonHeader(w, "text/plain")
+	w.Header().Add("Vary", "Accept-Encoding")
 
-		n, err := utils.ReadTo(nil, r, buf[6:n+6])
+	if acceptGzip(r) {
+		gw := newGzipResponseWriter(w)
+		defer gw.Close()
+
+		w = gw
+	}
+
+	w.WriteHeader(200)
+
+	flusher, _ := w.(http.Flusher)
+
+	send := func() bool {
+		n, err := io.Copy(w, f)
 		if err != nil {
-			return nil, err
+			utils.LogErr("follow log file, err:%s", err.Error())
+
+			return false
 		}
-		if n == 0 {
-			return nil, fmt.Errorf("oh no, left %d data to read", total)
+
+		if n > 0 && flusher != nil {
+			flusher.Flush()
 		}
 
-		total -= int64(n)
+		return true
+	}
 
-		copy(buf, fmt.Sprintf("%04X\r\n", n))
-		copy(buf[n+6:], []byte("\r\n"))
+	ticker := time.NewTicker(logFollowInterval)
+	defer ticker.Stop()
 
-		return buf[:n+8], nil
-	}
+	for send() {
+		select {
+		case <-done:
+			// send the data written before the job ended.
+			send()
 
-	write := func(data []byte) error {
-		return utils.Write(nil, w, data)
-	}
+			return nil
 
-	if err := utils.TransferData(read, write); err != nil {
-		return
+		case <-r.Context().Done():
+			return nil
+
+		case <-ticker.C:
+		}
 	}
 
-	fmt.Fprint(w, "0\r\n\r\n")
+	return nil
 }
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLog(t *testing.T) string {
+	f := filepath.Join(t.TempDir(), "_log")
+
+	data := ""
+	for i := 1; i <= 5; i++ {
+		data += strings.Repeat("log line ", i) + "\n"
+	}
+
+	if err := ioutil.WriteFile(f, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the Last-Modified and ETag depend on it.
+	mtime := time.Unix(1600000000, 0)
+	if err := os.Chtimes(f, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	return f
+}
+
+func TestUploadLogGolden(t *testing.T) {
+	b := BuildController{}
+	f := newTestLog(t)
+
+	upload := func(start int64, end *int64) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if err := b.uploadLog(f, start, end, w, r); err != nil {
+				b.replyMsg(w, 400, err.Error())
+			}
+		}
+	}
+
+	end := int64(30)
+	etag := `If-None-Match: "16345785d8a00000-0-8c"`
+
+	cases := []struct {
+		name   string
+		h      http.HandlerFunc
+		header []string
+	}{
+		{"log_whole", upload(0, nil), nil},
+		{"log_start_end", upload(10, &end), nil},
+		{"log_tail", upload(-20, nil), nil},
+		{"log_range", upload(0, nil), []string{"Range: bytes=5-14"}},
+		{"log_gzip", upload(0, nil), []string{"Accept-Encoding: gzip"}},
+		{"log_gzip_range", upload(0, nil), []string{"Accept-Encoding: gzip", "Range: bytes=5-14"}},
+		{"log_not_modified", upload(0, nil), []string{etag}},
+		{"log_too_big", upload(1000, nil), nil},
+		{"log_entry", func(w http.ResponseWriter, r *http.Request) {
+			if err := b.loginfo(f, w); err != nil {
+				t.Error(err)
+			}
+		}, nil},
+	}
+
+	for _, c := range cases {
+		checkGolden(t, c.name, rawResponse(t, c.h, http.MethodGet, c.header...))
+	}
+}
+
+func TestFollowLogGolden(t *testing.T) {
+	b := BuildController{}
+	f := newTestLog(t)
+
+	// the job has ended, so the log is sent once and the response ends.
+	done := make(chan struct{})
+	close(done)
+
+	follow := func(w http.ResponseWriter, r *http.Request) {
+		if err := b.followLog(f, 0, done, w, r); err != nil {
+			t.Error(err)
+		}
+	}
+
+	checkGolden(t, "log_follow", rawResponse(t, follow, http.MethodGet))
+	checkGolden(t, "log_follow_gzip", rawResponse(t, follow, http.MethodGet, "Accept-Encoding: gzip"))
+}
@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+var (
+	dateHeader          = regexp.MustCompile("(?m)^Date: .*\r\n")
+	contentLengthHeader = regexp.MustCompile("(?m)^Content-Length: .*\r\n")
+)
+
+// rawResponse returns the response of h on the wire except the Date
+// header. The body compressed by gzip is replaced by the decompressed one
+// and its length is removed, because the compressed bytes depend on the
+// version of Go.
+func rawResponse(t *testing.T, h http.HandlerFunc, method string, header ...string) []byte {
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	c, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Close()
+
+	req := method + " /test HTTP/1.1\r\nHost: worker\r\n"
+	for _, item := range header {
+		req += item + "\r\n"
+	}
+
+	if _, err := c.Write([]byte(req + "\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the controllers close the connection after every response.
+	b, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b = dateHeader.ReplaceAll(b, nil)
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		return b
+	}
+
+	gr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := ioutil.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	head := contentLengthHeader.ReplaceAll(b[:bytes.Index(b, []byte("\r\n\r\n"))+4], nil)
+
+	return append(append(head, "[decompressed]\n"...), body...)
+}
+
+func checkGolden(t *testing.T, name string, got []byte) {
+	f := filepath.Join("testdata", name+".golden")
+
+	if *update {
+		if err := ioutil.WriteFile(f, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		return
+	}
+
+	want, err := ioutil.ReadFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s:\ngot:\n%q\nwant:\n%q", name, got, want)
+	}
+}
+
+func TestReplyGolden(t *testing.T) {
+	b := BuildController{}
+
+	checkGolden(t, "reply_msg", rawResponse(t, func(w http.ResponseWriter, r *http.Request) {
+		b.replyMsg(w, 0, "so much work, so little time...")
+	}, http.MethodGet))
+
+	checkGolden(t, "build_method", rawResponse(t, b.Build, http.MethodGet))
+}
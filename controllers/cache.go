@@ -0,0 +1,24 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/zengchen1024/obs-worker/worker"
+)
+
+// CheckCache checks and repairs the cache, then reports what it changed.
+// The cached rpms are verified if verify is set.
+func (a APIController) CheckCache(w http.ResponseWriter, r *http.Request) {
+	if !a.checkMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	v, err := worker.CheckCache(isTrue(r.URL.Query().Get("verify")))
+	if err != nil {
+		a.replyError(w, err)
+
+		return
+	}
+
+	a.replyJSON(w, http.StatusOK, v)
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/zengchen1024/obs-worker/worker"
+)
+
+type apiDrain struct {
+	Draining bool `json:"draining"`
+}
+
+func drainExit(r *http.Request) bool {
+	return isTrue(r.URL.Query().Get("exit"))
+}
+
+func isTrue(v string) bool {
+	return v != "" && v != "0" && v != "false"
+}
+
+func (b BuildController) Drain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		b.replyMsg(w, 405, "method is not allowed")
+
+		return
+	}
+
+	worker.Drain(drainExit(r))
+
+	b.replyMsg(w, 0, "draining")
+}
+
+func (b BuildController) Undrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		b.replyMsg(w, 405, "method is not allowed")
+
+		return
+	}
+
+	worker.Undrain()
+
+	b.replyMsg(w, 0, "ok")
+}
+
+func (a APIController) Drain(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+
+	case http.MethodPost:
+		worker.Drain(drainExit(r))
+
+	case http.MethodDelete:
+		worker.Undrain()
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		a.replyJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method is not allowed"})
+
+		return
+	}
+
+	a.replyJSON(w, http.StatusOK, apiDrain{Draining: worker.IsDraining()})
+}
@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func acceptGzip(r *http.Request) bool {
+	for _, item := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		v := strings.Split(item, ";")
+		if strings.TrimSpace(v[0]) != "gzip" {
+			continue
+		}
+
+		if len(v) == 1 {
+			return true
+		}
+
+		q := strings.TrimSpace(v[1])
+		if !strings.HasPrefix(q, "q=") {
+			return true
+		}
+
+		f, err := strconv.ParseFloat(strings.TrimPrefix(q, "q="), 64)
+
+		return err == nil && f > 0
+	}
+
+	return false
+}
+
+// gzipResponseWriter compresses the body of a 200 response only,
+// the other responses are passed through.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func newGzipResponseWriter(w http.ResponseWriter) *gzipResponseWriter {
+	return &gzipResponseWriter{ResponseWriter: w}
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+
+	w.wroteHeader = true
+
+	if code == http.StatusOK {
+		h := w.Header()
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		h.Set("Content-Encoding", "gzip")
+
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.gz == nil {
+		return w.ResponseWriter.Write(data)
+	}
+
+	return w.gz.Write(data)
+}
+
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *gzipResponseWriter) Close() error {
+	if w.gz == nil {
+		return nil
+	}
+
+	return w.gz.Close()
+}
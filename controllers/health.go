@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/zengchen1024/obs-worker/worker"
+)
+
+const (
+	statusOK       = "ok"
+	statusDegraded = "degraded"
+	statusUnready  = "unready"
+)
+
+type healthStatus struct {
+	Status string          `json:"status"`
+	Slots  []worker.Health `json:"slots,omitempty"`
+}
+
+type HealthController struct {
+	APIController
+}
+
+// Live reports the process is alive and serving.
+func (h HealthController) Live(w http.ResponseWriter, r *http.Request) {
+	h.replyJSON(w, http.StatusOK, healthStatus{Status: statusOK})
+}
+
+// Ready reports whether the worker is able to build a job, that is
+// whether any slot is ready. The slots that are not are listed too.
+func (h HealthController) Ready(w http.ResponseWriter, r *http.Request) {
+	v := healthStatus{Slots: worker.CheckHealth()}
+
+	code := http.StatusOK
+	v.Status = readiness(v.Slots)
+	if v.Status == statusUnready {
+		code = http.StatusServiceUnavailable
+	}
+
+	h.replyJSON(w, code, v)
+}
+
+func readiness(slots []worker.Health) string {
+	n := 0
+	for i := range slots {
+		if slots[i].Ready {
+			n++
+		}
+	}
+
+	switch {
+	case n == 0:
+		return statusUnready
+	case n < len(slots):
+		return statusDegraded
+	default:
+		return statusOK
+	}
+}
@@ -0,0 +1,28 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/zengchen1024/obs-worker/worker"
+)
+
+func TestReadiness(t *testing.T) {
+	ready, unready := worker.Health{Ready: true}, worker.Health{}
+
+	cases := []struct {
+		name  string
+		slots []worker.Health
+		want  string
+	}{
+		{"no slot", nil, statusUnready},
+		{"all ready", []worker.Health{ready, ready}, statusOK},
+		{"one of them is draining", []worker.Health{unready, ready}, statusDegraded},
+		{"none is ready", []worker.Health{unready, unready}, statusUnready},
+	}
+
+	for _, c := range cases {
+		if v := readiness(c.slots); v != c.want {
+			t.Errorf("%s: got %s, want %s", c.name, v, c.want)
+		}
+	}
+}
@@ -10,8 +10,27 @@ func (b BuildController) jobid(r *http.Request) string {
 	return r.URL.Query().Get("jobid")
 }
 
+func (b BuildController) workerid(r *http.Request) string {
+	return r.URL.Query().Get("workerid")
+}
+
+func (b BuildController) buildManager(r *http.Request) *worker.BuildManager {
+	return worker.GetBuildManager(b.jobid(r), b.workerid(r))
+}
+
 func (b BuildController) JobInfo(w http.ResponseWriter, r *http.Request) {
-	v, err := worker.GetBuildManager().GetJob(b.jobid(r))
+	v, err := b.buildManager(r).GetJob(b.jobid(r))
+	if err != nil {
+		b.replyMsg(w, 500, err.Error())
+
+		return
+	}
+
+	b.reply(w, 0, &v)
+}
+
+func (b BuildController) JobProgress(w http.ResponseWriter, r *http.Request) {
+	v, err := b.buildManager(r).GetProgress(b.jobid(r))
 	if err != nil {
 		b.replyMsg(w, 500, err.Error())
 
@@ -21,8 +40,17 @@ func (b BuildController) JobInfo(w http.ResponseWriter, r *http.Request) {
 	b.reply(w, 0, &v)
 }
 
+// WorkerInfo requires the jobid or workerid when there are several slots,
+// because only one slot can be replied.
 func (b BuildController) WorkerInfo(w http.ResponseWriter, r *http.Request) {
-	v, err := worker.GetBuildManager().GetWorkerInfo(b.jobid(r))
+	bm, err := worker.FindBuildManager(b.jobid(r), b.workerid(r))
+	if err != nil {
+		b.replyMsg(w, 400, err.Error())
+
+		return
+	}
+
+	v, err := bm.GetWorkerInfo(b.jobid(r))
 	if err != nil {
 		b.replyMsg(w, 500, err.Error())
 
@@ -33,7 +61,7 @@ func (b BuildController) WorkerInfo(w http.ResponseWriter, r *http.Request) {
 }
 
 func (b BuildController) KillJob(w http.ResponseWriter, r *http.Request) {
-	err := worker.GetBuildManager().KillJob(b.jobid(r))
+	err := b.buildManager(r).KillJob(b.jobid(r))
 	if err != nil {
 		b.replyMsg(w, 500, err.Error())
 
@@ -44,7 +72,7 @@ func (b BuildController) KillJob(w http.ResponseWriter, r *http.Request) {
 }
 
 func (b BuildController) DiscardJob(w http.ResponseWriter, r *http.Request) {
-	err := worker.GetBuildManager().DiscardJob(b.jobid(r))
+	err := b.buildManager(r).DiscardJob(b.jobid(r))
 	if err != nil {
 		b.replyMsg(w, 500, err.Error())
 
@@ -55,7 +83,7 @@ func (b BuildController) DiscardJob(w http.ResponseWriter, r *http.Request) {
 }
 
 func (b BuildController) SetBadHostJob(w http.ResponseWriter, r *http.Request) {
-	err := worker.GetBuildManager().SetBadHostJob(b.jobid(r))
+	err := b.buildManager(r).SetBadHostJob(b.jobid(r))
 	if err != nil {
 		b.replyMsg(w, 500, err.Error())
 
@@ -66,7 +94,7 @@ func (b BuildController) SetBadHostJob(w http.ResponseWriter, r *http.Request) {
 }
 
 func (b BuildController) SetSysrqJob(w http.ResponseWriter, r *http.Request) {
-	err := worker.GetBuildManager().SetSysrqJob(b.jobid(r))
+	err := b.buildManager(r).SetSysrqJob(b.jobid(r))
 	if err != nil {
 		b.replyMsg(w, 500, err.Error())
 
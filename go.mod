@@ -1,12 +1,28 @@
 module github.com/zengchen1024/obs-worker
 
-go 1.16
+go 1.18
 
 require (
 	github.com/cavaliergopher/rpm v1.2.0
 	github.com/huaweicloud/golangsdk v0.0.0-20210831081626-d823fe11ceba
+	github.com/klauspost/compress v1.15.15
 	github.com/opensourceways/community-robot-lib v0.0.0-20220118064921-28924d0a1246
+	github.com/prometheus/client_golang v1.12.2
 	github.com/sirupsen/logrus v1.8.1
 	k8s.io/apimachinery v0.23.5
 	sigs.k8s.io/yaml v1.2.0
 )
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/prometheus/client_model v0.2.0 // indirect
+	github.com/prometheus/common v0.32.1 // indirect
+	github.com/prometheus/procfs v0.7.3 // indirect
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519 // indirect
+	golang.org/x/sys v0.0.0-20220114195835-da31bd327af9 // indirect
+	google.golang.org/protobuf v1.27.1 // indirect
+	gopkg.in/yaml.v2 v2.4.0 // indirect
+)
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"net/http"
 	"os"
@@ -13,13 +14,20 @@ import (
 
 	"github.com/zengchen1024/obs-worker/config"
 	"github.com/zengchen1024/obs-worker/controllers"
+	"github.com/zengchen1024/obs-worker/metrics"
+	"github.com/zengchen1024/obs-worker/utils"
 	"github.com/zengchen1024/obs-worker/worker"
 )
 
 type options struct {
-	config      string
-	port        int
-	gracePeriod time.Duration
+	config         string
+	port           int
+	gracePeriod    time.Duration
+	shutdownPolicy string
+}
+
+func (o *options) validate() error {
+	return worker.ValidateShutdownPolicy(o.shutdownPolicy)
 }
 
 func gatherOptions(fs *flag.FlagSet, args ...string) options {
@@ -37,6 +45,12 @@ func gatherOptions(fs *flag.FlagSet, args ...string) options {
 		"On shutdown, try to handle remaining events for the specified duration.",
 	)
 
+	fs.StringVar(
+		&o.shutdownPolicy, "shutdown-policy", worker.ShutdownPolicyWait,
+		"On shutdown, wait for the running job up to the grace period (wait) "+
+			"or kill it at once (kill). The killed job is reported as failed.",
+	)
+
 	fs.Parse(args)
 
 	return o
@@ -49,40 +63,106 @@ func main() {
 		flag.NewFlagSet(os.Args[0], flag.ExitOnError),
 		os.Args[1:]...,
 	)
+	if err := o.validate(); err != nil {
+		logrus.WithError(err).Fatal("invalid options")
+	}
 
 	cfg, err := config.Load(o.config)
 	if err != nil {
 		logrus.WithError(err).Fatal("load config failed")
 	}
 
+	if err := utils.InitHTTPClient(&cfg.HTTPClient, &cfg.ClientTLS); err != nil {
+		logrus.WithError(err).Fatal("init http client")
+	}
+
 	if err := worker.Init(&cfg.Build, o.port); err != nil {
 		logrus.WithError(err).Fatal("init worker")
 	}
 
-	defer worker.Exit()
+	run(&o, cfg)
+}
 
-	run(&o)
+func run(o *options, cfg *config.Config) {
+	register(controllers.NewAccessControl(&cfg.Access, cfg.Build.RepoServers))
+
+	httpServer := &http.Server{Addr: ":" + strconv.Itoa(o.port)}
+
+	if cfg.TLS.Enabled() {
+		tlsCfg, err := utils.NewServerTLSConfig(&cfg.TLS)
+		if err != nil {
+			logrus.WithError(err).Fatal("load tls config")
+		}
+
+		httpServer.TLSConfig = tlsCfg
+	}
+
+	go func() {
+		var err error
+
+		// the certificates are loaded by TLSConfig.
+		if httpServer.TLSConfig != nil {
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+
+		logrus.WithError(err).Info("Server exited.")
+	}()
+
+	<-interrupts.Context().Done()
+
+	shutdown(o, httpServer)
 }
 
-func run(o *options) {
-	defer interrupts.WaitForGracefulShutdown()
+// shutdown stops the worker and then the server within one grace period.
+// The server is kept up until the jobs are reported, so that they can
+// still be queried and killed while the worker is exiting.
+func shutdown(o *options, httpServer *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), o.gracePeriod)
+	defer cancel()
 
-	register()
+	worker.Exit(o.shutdownPolicy, o.gracePeriod)
 
-	httpServer := &http.Server{Addr: ":" + strconv.Itoa(o.port)}
+	logrus.Info("Server shutting down...")
 
-	interrupts.ListenAndServe(httpServer, o.gracePeriod)
+	if err := httpServer.Shutdown(ctx); err != nil {
+		logrus.WithError(err).Info("Error shutting down server...")
+	}
 }
 
-func register() {
+func register(ac *controllers.AccessControl) {
 	c := controllers.BuildController{}
 
-	http.HandleFunc("/build", c.Build)
-	http.HandleFunc("/info", c.JobInfo)
-	http.HandleFunc("/worker", c.WorkerInfo)
-	http.HandleFunc("/kill", c.KillJob)
-	http.HandleFunc("/discard", c.DiscardJob)
-	http.HandleFunc("/badhost", c.SetBadHostJob)
-	http.HandleFunc("/sysrq", c.SetSysrqJob)
-	http.HandleFunc("/logfile", c.GetBuildLog)
+	http.HandleFunc("/build", ac.Write(c.Build))
+	http.HandleFunc("/info", ac.Read(c.JobInfo))
+	http.HandleFunc("/progress", ac.Read(c.JobProgress))
+	http.HandleFunc("/worker", ac.Read(c.WorkerInfo))
+	http.HandleFunc("/kill", ac.Write(c.KillJob))
+	http.HandleFunc("/discard", ac.Write(c.DiscardJob))
+	http.HandleFunc("/badhost", ac.Write(c.SetBadHostJob))
+	http.HandleFunc("/sysrq", ac.Write(c.SetSysrqJob))
+	http.HandleFunc("/logfile", ac.Read(c.GetBuildLog))
+	http.HandleFunc("/drain", ac.Write(c.Drain))
+	http.HandleFunc("/undrain", ac.Write(c.Undrain))
+
+	a := controllers.APIController{}
+	p := controllers.APIPrefix
+
+	http.HandleFunc(p+"/job", ac.Read(a.Job))
+	http.HandleFunc(p+"/job/kill", ac.Write(a.KillJob))
+	http.HandleFunc(p+"/job/discard", ac.Write(a.DiscardJob))
+	http.HandleFunc(p+"/job/badhost", ac.Write(a.SetBadHostJob))
+	http.HandleFunc(p+"/job/log", ac.Read(a.Log))
+	http.HandleFunc(p+"/job/progress", ac.Read(a.Progress))
+	http.HandleFunc(p+"/worker", ac.Read(a.Worker))
+	http.HandleFunc(p+"/drain", ac.Write(a.Drain))
+	http.HandleFunc(p+"/cache/check", ac.Write(a.CheckCache))
+
+	h := controllers.HealthController{}
+
+	http.HandleFunc("/healthz", h.Live)
+	http.HandleFunc("/readyz", h.Ready)
+
+	http.HandleFunc("/metrics", ac.Read(metrics.Handler().ServeHTTP))
 }
@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "obs_worker"
+
+var (
+	jobs = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "jobs_total",
+			Help:      "Number of finished jobs by result code.",
+		},
+		[]string{"code"},
+	)
+
+	stageDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "stage_duration_seconds",
+			Help:      "Time spent in each build stage.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 16),
+		},
+		[]string{"stage"},
+	)
+
+	binariesDownloaded = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "binaries_downloaded_total",
+			Help:      "Number of binaries downloaded from the repo servers.",
+		},
+	)
+
+	cacheHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "binary_cache_hits_total",
+			Help:      "Number of binaries taken from the binary cache.",
+		},
+	)
+
+	transferBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "transfer_bytes_total",
+			Help:      "Bytes transferred with the repo and source servers.",
+		},
+		[]string{"direction"},
+	)
+
+	cacheSize = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "binary_cache_size_bytes",
+			Help:      "Size of the binary cache after the last pruning.",
+		},
+	)
+
+	cacheEvictions = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "binary_cache_evictions_total",
+			Help:      "Number of files evicted from the binary cache.",
+		},
+	)
+
+	requestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "server_request_duration_seconds",
+			Help:      "Latency of the requests to the repo and source servers.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 16),
+		},
+		[]string{"server", "api"},
+	)
+
+	requestErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "server_request_errors_total",
+			Help:      "Number of failed requests to the repo and source servers.",
+		},
+		[]string{"server", "api"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		jobs,
+		stageDuration,
+		binariesDownloaded,
+		cacheHits,
+		transferBytes,
+		cacheSize,
+		cacheEvictions,
+		requestDuration,
+		requestErrors,
+	)
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+func AddJob(code string) {
+	jobs.WithLabelValues(code).Inc()
+}
+
+func ObserveStage(stage string, d time.Duration) {
+	stageDuration.WithLabelValues(stage).Observe(d.Seconds())
+}
+
+func AddBinariesDownloaded(n int) {
+	binariesDownloaded.Add(float64(n))
+}
+
+func AddCacheHits(n int) {
+	cacheHits.Add(float64(n))
+}
+
+func AddDownloadBytes(n int64) {
+	transferBytes.WithLabelValues("download").Add(float64(n))
+}
+
+func AddUploadBytes(n int64) {
+	transferBytes.WithLabelValues("upload").Add(float64(n))
+}
+
+func SetCacheSize(n int64) {
+	cacheSize.Set(float64(n))
+}
+
+func AddCacheEvictions(n int) {
+	cacheEvictions.Add(float64(n))
+}
+
+func ObserveRequest(server, api string, d time.Duration) {
+	requestDuration.WithLabelValues(server, api).Observe(d.Seconds())
+}
+
+func AddRequestError(server, api string) {
+	requestErrors.WithLabelValues(server, api).Inc()
+}
@@ -1,12 +1,13 @@
 package binary
 
 import (
+	"context"
 	"encoding/json"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"path/filepath"
-	"strconv"
 	"strings"
 
 	"github.com/zengchen1024/obs-worker/sdk/filereceiver"
@@ -14,12 +15,16 @@ import (
 )
 
 type CommonOpts struct {
-	WorkerId   string   `json:"workerid,omitempty"`
-	Project    string   `json:"project" required:"true"`
-	Repository string   `json:"repository" required:"true"`
-	Arch       string   `json:"arch" required:"true"`
-	Modules    []string `json:"-"`
-	Binaries   []string `json:"-"`
+	WorkerId   string `json:"workerid,omitempty"`
+	Project    string `json:"project" required:"true"`
+	Repository string `json:"repository" required:"true"`
+	Arch       string `json:"arch" required:"true"`
+
+	// Server is the repo server which the binary proxy forwards to.
+	Server string `json:"server,omitempty"`
+
+	Modules  []string `json:"-"`
+	Binaries []string `json:"-"`
 }
 
 func (o *CommonOpts) values() (url.Values, error) {
@@ -69,7 +74,7 @@ func (o *ListOpts) toQuery() (string, error) {
 	return q.Encode(), nil
 }
 
-func List(endpoint string, opts *ListOpts) (binaries BinaryVersionList, err error) {
+func List(ctx context.Context, endpoint string, opts *ListOpts) (binaries BinaryVersionList, err error) {
 	q, err := opts.toQuery()
 	if err != nil {
 		return
@@ -80,18 +85,14 @@ func List(endpoint string, opts *ListOpts) (binaries BinaryVersionList, err erro
 		return
 	}
 
-	req, err := http.NewRequest(http.MethodGet, urlStr, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
 	if err != nil {
 		return
 	}
 
 	handle := func(h http.Header, r io.Reader) error {
-		n, err := strconv.Atoi(h.Get("content-length"))
-		if err != nil {
-			return err
-		}
-
-		b, err := utils.ReadData(r, n)
+		// the content-length is absent when the response is compressed.
+		b, err := ioutil.ReadAll(r)
 		if err != nil {
 			return err
 		}
@@ -108,6 +109,9 @@ type DownloadOpts struct {
 	CommonOpts
 
 	MetaOnly bool `json:"-"`
+
+	// Digests are computed besides the md5 of each file.
+	Digests filereceiver.Digest `json:"-"`
 }
 
 func (o *DownloadOpts) toQuery() (string, error) {
@@ -123,7 +127,7 @@ func (o *DownloadOpts) toQuery() (string, error) {
 	return q.Encode(), nil
 }
 
-func Download(endpoint string, opts *DownloadOpts, saveToDir string) (meta []filereceiver.CPIOFileMeta, err error) {
+func Download(ctx context.Context, endpoint string, opts *DownloadOpts, saveToDir string) (meta []filereceiver.CPIOFileMeta, err error) {
 	q, err := opts.toQuery()
 	if err != nil {
 		return
@@ -134,7 +138,7 @@ func Download(endpoint string, opts *DownloadOpts, saveToDir string) (meta []fil
 		return
 	}
 
-	req, err := http.NewRequest(http.MethodGet, urlStr, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
 	if err != nil {
 		return
 	}
@@ -144,10 +148,14 @@ func Download(endpoint string, opts *DownloadOpts, saveToDir string) (meta []fil
 			name string,
 			header *filereceiver.CPIOFileHeader,
 		) (string, string, bool, error) {
-			return name, filepath.Join(saveToDir, name), false, nil
+			if header.GetType() != filereceiver.CPIOTypeFile {
+				return "", "", false, nil
+			}
+
+			return name, filepath.Join(saveToDir, name), true, nil
 		}
 
-		meta, err = filereceiver.ReceiveCpioFiles(r, check)
+		meta, err = filereceiver.ReceiveCpioFiles(r, check, opts.Digests)
 
 		return err
 	}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -35,7 +36,7 @@ func (o *DownloadOpts) toQuery() (string, error) {
 	return q.Encode(), nil
 }
 
-func Download(endpoint string, opts *DownloadOpts, saveTo string) error {
+func Download(ctx context.Context, endpoint string, opts *DownloadOpts, saveTo string) error {
 	q, err := opts.toQuery()
 	if err != nil {
 		return err
@@ -46,7 +47,7 @@ func Download(endpoint string, opts *DownloadOpts, saveTo string) error {
 		return err
 	}
 
-	req, err := http.NewRequest(http.MethodGet, urlStr, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
 	if err != nil {
 		return err
 	}
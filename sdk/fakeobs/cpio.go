@@ -0,0 +1,103 @@
+package fakeobs
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/zengchen1024/obs-worker/sdk/filereceiver"
+	"github.com/zengchen1024/obs-worker/utils"
+)
+
+type cpioFile struct {
+	name string
+	path string
+}
+
+// writeCPIO sends the files as the cpio stream in the format of OBS.
+// The response is broken if a file fails to be read, as the real one.
+func writeCPIO(w http.ResponseWriter, files []cpioFile) {
+	w.Header().Set("Content-Type", "application/x-cpio")
+
+	for i := range files {
+		if err := writeCPIOFile(w, &files[i]); err != nil {
+			utils.LogErr("write cpio file:%s, err:%s", files[i].path, err.Error())
+
+			return
+		}
+	}
+
+	io.WriteString(w, filereceiver.EncodeEmpty())
+}
+
+func writeCPIOFile(w io.Writer, file *cpioFile) error {
+	f, err := os.Open(file.path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	h, pad := filereceiver.Encode(info, file.name, nil)
+
+	if _, err := io.WriteString(w, h); err != nil {
+		return err
+	}
+
+	if _, err := io.CopyN(w, f, info.Size()); err != nil {
+		return err
+	}
+
+	_, err = w.Write(make([]byte, pad))
+
+	return err
+}
+
+func accept(r *http.Request, encoding string) bool {
+	for _, item := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		v := strings.TrimSpace(strings.Split(item, ";")[0])
+		if v == encoding {
+			return true
+		}
+	}
+
+	return false
+}
+
+type encodedWriter struct {
+	http.ResponseWriter
+
+	w io.WriteCloser
+}
+
+func newEncodedWriter(w http.ResponseWriter, encoding string) (*encodedWriter, error) {
+	ew, err := utils.NewEncoder(w, encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Header().Set("Content-Encoding", encoding)
+
+	return &encodedWriter{ResponseWriter: w, w: ew}, nil
+}
+
+func (e *encodedWriter) WriteHeader(code int) {
+	e.Header().Del("Content-Length")
+	e.ResponseWriter.WriteHeader(code)
+}
+
+func (e *encodedWriter) Write(b []byte) (int, error) {
+	e.Header().Del("Content-Length")
+
+	return e.w.Write(b)
+}
+
+func (e *encodedWriter) Close() error {
+	return e.w.Close()
+}
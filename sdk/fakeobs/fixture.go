@@ -0,0 +1,97 @@
+package fakeobs
+
+import (
+	"crypto/md5"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zengchen1024/obs-worker/utils"
+)
+
+// Package is the fixtures of a package to build.
+type Package struct {
+	Project    string
+	Repository string
+	Arch       string
+	Name       string
+
+	// Sources are the source files, and Config is the project config.
+	Sources map[string]string
+	Config  string
+
+	// Binaries are the files of repository, such as gcc.rpm and gcc.meta.
+	Binaries map[string][]byte
+
+	// OldPackages are the files built last time.
+	OldPackages map[string][]byte
+}
+
+// WritePackage writes the fixtures of p to dir, and returns the verifymd5
+// of its sources.
+func WritePackage(dir string, p *Package) (string, error) {
+	files := map[string][]byte{
+		filepath.Join("config", p.Project, p.Repository): []byte(p.Config),
+	}
+
+	names := make([]string, 0, len(p.Sources))
+	for name, data := range p.Sources {
+		files[filepath.Join("sources", p.Project, p.Name, name)] = []byte(data)
+
+		names = append(names, name)
+	}
+
+	repo := filepath.Join("repos", p.Project, p.Repository, p.Arch)
+	for name, data := range p.Binaries {
+		files[filepath.Join(repo, name)] = data
+	}
+
+	old := filepath.Join("build", p.Project, p.Repository, p.Arch, p.Name)
+	for name, data := range p.OldPackages {
+		files[filepath.Join(old, name)] = data
+	}
+
+	for name, data := range files {
+		f := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+			return "", err
+		}
+
+		if err := utils.WriteFile(f, data); err != nil {
+			return "", err
+		}
+	}
+
+	// same as the worker verifies the sources.
+	sort.Strings(names)
+
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = fmt.Sprintf("%s  %s", utils.GenMD5([]byte(p.Sources[name])), name)
+	}
+
+	return utils.GenMD5([]byte(strings.Join(lines, "\n") + "\n")), nil
+}
+
+// RPM returns a rpm whose payload is data. It has only the md5 in the
+// signature header, which is enough for the hdrmd5.
+func RPM(data string) []byte {
+	lead := make([]byte, 96)
+	copy(lead, "\xed\xab\xee\xdb")
+
+	sig := []byte{
+		0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0,
+		0, 0, 0, 1, 0, 0, 0, 16,
+		0, 0, 0x03, 0xec, 0, 0, 0, 7, 0, 0, 0, 0, 0, 0, 0, 16,
+	}
+
+	sum := md5.Sum([]byte(data))
+
+	r := append(lead, sig...)
+	r = append(r, sum[:]...)
+
+	return append(r, data...)
+}
@@ -0,0 +1,407 @@
+package fakeobs
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zengchen1024/obs-worker/sdk/binary"
+	"github.com/zengchen1024/obs-worker/sdk/filereceiver"
+	"github.com/zengchen1024/obs-worker/utils"
+)
+
+var knownBins = []string{".rpm", ".deb", ".pkg.tar.gz", ".pkg.tar.xz", ".pkg.tar.zst"}
+
+func (s *Server) path(v ...string) string {
+	for _, item := range v {
+		// the fixtures must not be escaped.
+		if item == "" || item == "." || item == ".." || strings.Contains(item, "/") {
+			return ""
+		}
+	}
+
+	return filepath.Join(append([]string{s.dir}, v...)...)
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, f string) {
+	if f == "" {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	fi, err := os.Stat(f)
+	if err != nil || fi.IsDir() {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	http.ServeFile(w, r, f)
+}
+
+func (s *Server) serveDir(w http.ResponseWriter, r *http.Request, dir string) {
+	if dir == "" {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	items, err := os.ReadDir(dir)
+	if err != nil {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	files := make([]cpioFile, 0, len(items))
+	for _, item := range items {
+		if !item.IsDir() {
+			files = append(files, cpioFile{
+				name: item.Name(),
+				path: filepath.Join(dir, item.Name()),
+			})
+		}
+	}
+
+	writeCPIO(w, files)
+}
+
+func (s *Server) getSources(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	dir := s.path("sources", q.Get("project"), q.Get("package"))
+
+	// the sources of a revision are preferred if they exist.
+	if v := q.Get("srcmd5"); v != "" && dir != "" {
+		if d := filepath.Join(dir, v); isDir(d) {
+			dir = d
+		}
+	}
+
+	s.serveDir(w, r, dir)
+}
+
+func (s *Server) getConfig(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	s.serveFile(w, r, s.path("config", q.Get("project"), q.Get("repository")))
+}
+
+func (s *Server) getSSLCert(w http.ResponseWriter, r *http.Request) {
+	s.serveFile(w, r, s.path("sslcert", r.URL.Query().Get("project")))
+}
+
+func (s *Server) getPreInstallImageInfos(w http.ResponseWriter, r *http.Request) {
+	if _, err := io.Copy(io.Discard, r.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	s.serveFile(w, r, filepath.Join(s.dir, "preinstallimageinfos"))
+}
+
+func (s *Server) repoDir(r *http.Request) string {
+	q := r.URL.Query()
+
+	return s.path("repos", q.Get("project"), q.Get("repository"), q.Get("arch"))
+}
+
+func (s *Server) requestedBinaries(r *http.Request) []string {
+	v := r.URL.Query().Get("binaries")
+	if v == "" {
+		return nil
+	}
+
+	return strings.Split(v, ",")
+}
+
+// findBinary returns the file name of the binary in the dir.
+func findBinary(dir, bin string) string {
+	for _, suffix := range knownBins {
+		if name := bin + suffix; isFile(filepath.Join(dir, name)) {
+			return name
+		}
+	}
+
+	return ""
+}
+
+func (s *Server) getBinaryVersions(w http.ResponseWriter, r *http.Request) {
+	dir := s.repoDir(r)
+	if dir == "" || !isDir(dir) {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	bins := s.requestedBinaries(r)
+	if bins == nil {
+		bins = listBinaries(dir)
+	}
+
+	nometa := r.URL.Query().Get("nometa") == "1"
+
+	list := binary.BinaryVersionList{}
+
+	for _, bin := range bins {
+		name := findBinary(dir, bin)
+		if name == "" {
+			list.Binaries = append(list.Binaries, binary.Binary{
+				Name:  bin,
+				Error: "not available",
+			})
+
+			continue
+		}
+
+		v, err := binaryVersion(dir, name, nometa)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+			return
+		}
+
+		list.Binaries = append(list.Binaries, v)
+	}
+
+	b, err := xml.Marshal(&list)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write(b)
+}
+
+func binaryVersion(dir, name string, nometa bool) (binary.Binary, error) {
+	v := binary.Binary{Name: name}
+
+	f := filepath.Join(dir, name)
+
+	fi, err := os.Stat(f)
+	if err != nil {
+		return v, err
+	}
+
+	v.SizeK = int((fi.Size() + 1023) >> 10)
+
+	fo, err := os.Open(f)
+	if err != nil {
+		return v, err
+	}
+
+	defer fo.Close()
+
+	if v.HdrMD5, err = filereceiver.HdrMD5(fo); err != nil {
+		return v, err
+	}
+
+	if v.HdrMD5 == "" {
+		// not a rpm, use the md5 of file instead.
+		if v.HdrMD5, err = utils.GenMd5OfFile(f); err != nil {
+			return v, err
+		}
+	}
+
+	if m := filepath.Join(dir, binName(name)+".meta"); !nometa && isFile(m) {
+		if v.MetaMD5, err = utils.GenMd5OfFile(m); err != nil {
+			return v, err
+		}
+	}
+
+	return v, nil
+}
+
+func binName(name string) string {
+	for _, suffix := range knownBins {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix)
+		}
+	}
+
+	return name
+}
+
+func listBinaries(dir string) []string {
+	items, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	r := []string{}
+	for _, item := range items {
+		if n := item.Name(); !item.IsDir() && binName(n) != n {
+			r = append(r, binName(n))
+		}
+	}
+
+	return r
+}
+
+// getBinaries sends the binaries and their meta files. The missing
+// ones are skipped.
+func (s *Server) getBinaries(w http.ResponseWriter, r *http.Request) {
+	dir := s.repoDir(r)
+	if dir == "" || !isDir(dir) {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	q := r.URL.Query()
+	metaonly := q.Get("metaonly") == "1"
+	nometa := q.Get("nometa") == "1"
+
+	files := []cpioFile{}
+	add := func(name string) {
+		if f := filepath.Join(dir, name); isFile(f) {
+			files = append(files, cpioFile{name: name, path: f})
+		}
+	}
+
+	for _, bin := range s.requestedBinaries(r) {
+		if !metaonly {
+			if name := findBinary(dir, bin); name != "" {
+				add(name)
+			}
+		}
+
+		if metaonly || !nometa {
+			add(bin + ".meta")
+		}
+	}
+
+	writeCPIO(w, files)
+}
+
+// getBuild serves the files of /build/<project>/<repository>/<arch>/...,
+// the dir is sent as cpio if view is cpio.
+func (s *Server) getBuild(w http.ResponseWriter, r *http.Request) {
+	v := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/build/"), "/"), "/")
+
+	f := s.path(append([]string{"build"}, v...)...)
+
+	if r.URL.Query().Get("view") == "cpio" {
+		s.serveDir(w, r, f)
+	} else {
+		s.serveFile(w, r, f)
+	}
+}
+
+// putJob saves the uploaded files and records them.
+func (s *Server) putJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is allowed", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	q := r.URL.Query()
+
+	v := Upload{
+		Job:      q.Get("job"),
+		Arch:     q.Get("arch"),
+		JobId:    q.Get("jobid"),
+		Code:     q.Get("code"),
+		WorkerId: q.Get("workerid"),
+		Encoding: r.Header.Get("Content-Encoding"),
+		KiwiTree: q.Get("kiwitree") == "1",
+	}
+
+	body, err := utils.NewDecoder(r.Body, v.Encoding)
+	if err != nil {
+		w.Header().Set("Accept-Encoding", "gzip, zstd")
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+
+		return
+	}
+
+	defer body.Close()
+
+	dir, err := os.MkdirTemp(s.uploadDir, "putjob")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	check := func(name string, h *filereceiver.CPIOFileHeader) (string, string, bool, error) {
+		if v := filepath.Clean(name); filepath.IsAbs(v) || v == ".." || strings.HasPrefix(v, "../") {
+			return "", "", false, fmt.Errorf("invalid file name:%s", name)
+		}
+
+		f := filepath.Join(dir, name)
+
+		return name, f, true, os.MkdirAll(filepath.Dir(f), 0755)
+	}
+
+	metas, err := filereceiver.ReceiveCpioFiles(body, check, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	for i := range metas {
+		item := &metas[i]
+
+		v.Files = append(v.Files, UploadFile{
+			Name: item.Name,
+			Path: filepath.Join(dir, item.Name),
+			Size: item.Size,
+			MD5:  item.MD5,
+		})
+	}
+
+	s.addUpload(v)
+
+	w.Write([]byte("<status code=\"ok\" />\n"))
+}
+
+// worker records the registration of worker.
+func (s *Server) worker(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	v := Registration{
+		Method:   r.Method,
+		WorkerId: q.Get("workerid"),
+		State:    q.Get("state"),
+		Arch:     q.Get("arch"),
+		Port:     q.Get("port"),
+	}
+
+	if r.Method == http.MethodPost {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		v.Worker = b
+	}
+
+	s.addRegistration(v)
+
+	w.Write([]byte("<status code=\"ok\" />\n"))
+}
+
+func isDir(f string) bool {
+	v, err := os.Stat(f)
+
+	return err == nil && v.IsDir()
+}
+
+func isFile(f string) bool {
+	v, err := os.Stat(f)
+
+	return err == nil && v.Mode().IsRegular()
+}
@@ -0,0 +1,198 @@
+// Package fakeobs is a fake of the repo and source servers of OBS. It serves
+// the files in a fixture dir and records the uploads and registrations, so
+// the build can be exercised without a real backend.
+//
+// The layout of fixture dir is:
+//
+//	sources/<project>/<package>/*              /getsources
+//	config/<project>/<repository>              /getconfig
+//	sslcert/<project>                          /getsslcert
+//	preinstallimageinfos                       /getpreinstallimageinfos
+//	repos/<project>/<repository>/<arch>/*      /getbinaryversions, /getbinaries
+//	build/<project>/<repository>/<arch>/...    /build/..., view=cpio for a dir
+//
+// The preinstallimageinfos is the Storable data responded by the real server.
+package fakeobs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+type Registration struct {
+	Method   string
+	WorkerId string
+	State    string
+	Arch     string
+	Port     string
+
+	// Worker is the body of POST.
+	Worker []byte
+}
+
+type UploadFile struct {
+	Name string
+	Path string
+	Size int64
+	MD5  string
+}
+
+type Upload struct {
+	Job      string
+	Arch     string
+	JobId    string
+	Code     string
+	WorkerId string
+	Encoding string
+	KiwiTree bool
+	Files    []UploadFile
+}
+
+type Server struct {
+	*httptest.Server
+
+	dir       string
+	uploadDir string
+
+	lock          sync.Mutex
+	uploads       []Upload
+	registrations []Registration
+	requests      []string
+	status        map[string]int
+	encoding      string
+}
+
+// New starts the server which serves the fixtures in dir.
+func New(dir string) (*Server, error) {
+	tmp, err := os.MkdirTemp("", "fakeobs")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		dir:       dir,
+		uploadDir: tmp,
+		status:    make(map[string]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/getsources", s.getSources)
+	mux.HandleFunc("/getconfig", s.getConfig)
+	mux.HandleFunc("/getsslcert", s.getSSLCert)
+	mux.HandleFunc("/getpreinstallimageinfos", s.getPreInstallImageInfos)
+	mux.HandleFunc("/getbinaryversions", s.getBinaryVersions)
+	mux.HandleFunc("/getbinaries", s.getBinaries)
+	mux.HandleFunc("/build/", s.getBuild)
+	mux.HandleFunc("/putjob", s.putJob)
+	mux.HandleFunc("/worker", s.worker)
+
+	s.Server = httptest.NewServer(s.handle(mux))
+
+	return s, nil
+}
+
+func (s *Server) Close() {
+	s.Server.Close()
+
+	os.RemoveAll(s.uploadDir)
+}
+
+// SetStatus makes the api, such as "/getbinaries", respond with the status
+// code. The api works again if the code is 0.
+func (s *Server) SetStatus(api string, code int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if code == 0 {
+		delete(s.status, api)
+	} else {
+		s.status[api] = code
+	}
+}
+
+// SetEncoding compresses the responses with gzip or zstd if the client
+// accepts it.
+func (s *Server) SetEncoding(encoding string) {
+	s.lock.Lock()
+	s.encoding = encoding
+	s.lock.Unlock()
+}
+
+func (s *Server) Uploads() []Upload {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]Upload{}, s.uploads...)
+}
+
+func (s *Server) Registrations() []Registration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]Registration{}, s.registrations...)
+}
+
+// Requests returns the path and query of the requests in order.
+func (s *Server) Requests() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]string{}, s.requests...)
+}
+
+func (s *Server) handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		s.requests = append(s.requests, r.URL.RequestURI())
+		code := s.status[apiOf(r.URL)]
+		encoding := s.encoding
+		s.lock.Unlock()
+
+		if code != 0 {
+			http.Error(w, http.StatusText(code), code)
+
+			return
+		}
+
+		if encoding != "" && r.Header.Get("Range") == "" && accept(r, encoding) {
+			ew, err := newEncodedWriter(w, encoding)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+
+				return
+			}
+
+			defer ew.Close()
+
+			w = ew
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) addUpload(v Upload) {
+	s.lock.Lock()
+	s.uploads = append(s.uploads, v)
+	s.lock.Unlock()
+}
+
+func (s *Server) addRegistration(v Registration) {
+	s.lock.Lock()
+	s.registrations = append(s.registrations, v)
+	s.lock.Unlock()
+}
+
+// apiOf returns the first element of path, such as "/build".
+func apiOf(u *url.URL) string {
+	p := strings.TrimPrefix(u.Path, "/")
+	if i := strings.Index(p, "/"); i >= 0 {
+		p = p[:i]
+	}
+
+	return "/" + p
+}
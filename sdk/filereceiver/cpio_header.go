@@ -2,12 +2,15 @@ package filereceiver
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"strconv"
 	"strings"
 )
 
 type CPIOFileHeader struct {
+	Ino      int64
+	Nlink    int64
 	Mtime    int64
 	Mode     int64
 	Size     int64
@@ -39,34 +42,43 @@ func (h *CPIOFileHeader) extract(bs []byte) error {
 		return fmt.Errorf("not cpio file")
 	}
 
-	substr := func(start, n int) string {
-		return string(bs[start : start+n])
-	}
+	var err error
+
+	// hex parses the field which has 8 hex digits.
+	hex := func(start int) int64 {
+		v, err1 := strconv.ParseUint(string(bs[start:start+8]), 16, 32)
+		if err1 != nil && err == nil {
+			err = fmt.Errorf("invalid field at %d", start)
+		}
 
-	hex := func(s string) int64 {
-		v, _ := strconv.ParseInt(s, 16, 0)
-		return v
+		return int64(v)
 	}
 
-	size := hex(substr(54, 8))
+	size := hex(54)
 	if size == 0xffffffff {
-		size = (hex(substr(78, 8)) << 32) + hex(substr(86, 8))
-		if size < 0xffffffff {
+		size = (hex(78) << 32) + hex(86)
+		if size < 0xffffffff || size > math.MaxInt64>>1 {
 			return fmt.Errorf("invalid size")
 		}
 	}
 
-	namesize := hex(substr(94, 8))
+	namesize := hex(94)
 	if namesize > 8192 {
 		return fmt.Errorf("ridiculous long filename")
 	}
 
-	h.Mode = hex(substr(14, 8))
-	h.Mtime = hex(substr(46, 8))
+	if namesize == 0 {
+		return fmt.Errorf("empty filename")
+	}
+
+	h.Ino = hex(6)
+	h.Mode = hex(14)
+	h.Nlink = hex(38)
+	h.Mtime = hex(46)
 	h.Namesize = int(namesize)
 	h.Size = size
 
-	return nil
+	return err
 }
 
 func (h *CPIOFileHeader) Marshal() string {
@@ -118,6 +130,12 @@ func Encode(info os.FileInfo, name string, cpioType *uint) (string, int) {
 	return encode(&h, name), h.GetPad()
 }
 
+// EncodeHeader returns the header and the name of an entry whose
+// mode contains the cpio type, such as a directory or symlink.
+func EncodeHeader(h CPIOFileHeader, name string) string {
+	return encode(&h, name)
+}
+
 func EncodeEmpty() string {
 	return encode(&CPIOFileHeader{}, "TRAILER!!!")
 }
@@ -0,0 +1,37 @@
+package filereceiver
+
+import (
+	"testing"
+)
+
+func FuzzCPIOFileHeaderExtract(f *testing.F) {
+	f.Add([]byte(EncodeEmpty()))
+	f.Add([]byte(EncodeHeader(CPIOFileHeader{Mode: 0100644, Mtime: 1, Size: 10}, "a/b")))
+	f.Add([]byte(EncodeHeader(CPIOFileHeader{Mode: 0100644, Size: 1 << 33}, "big")))
+	f.Add([]byte(EncodeHeader(CPIOFileHeader{Mode: 0120777, Size: 3}, "link")))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		h := CPIOFileHeader{}
+		if err := h.extract(b); err != nil {
+			return
+		}
+
+		if h.Size < 0 || h.GetFileStreamSize() < h.Size {
+			t.Fatalf("invalid size:%d", h.Size)
+		}
+
+		if h.Namesize <= 0 || h.Namesize > 8192 {
+			t.Fatalf("invalid name size:%d", h.Namesize)
+		}
+
+		// the header written by Marshal is parsed as the same.
+		v := CPIOFileHeader{}
+		if err := v.extract([]byte(h.Marshal())); err != nil {
+			t.Fatalf("extract the marshaled header, err:%v", err)
+		}
+
+		if v.Mode != h.Mode || v.Mtime != h.Mtime || v.Size != h.Size || v.Namesize != h.Namesize {
+			t.Fatalf("got %+v, want %+v", v, h)
+		}
+	})
+}
@@ -3,30 +3,79 @@ package filereceiver
 import (
 	"fmt"
 	"io"
+	"os"
+	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/zengchen1024/obs-worker/utils"
 )
 
+const (
+	CPIOTypeFIFO    = 0x1
+	CPIOTypeChar    = 0x2
+	CPIOTypeDir     = 0x4
+	CPIOTypeBlock   = 0x6
+	CPIOTypeFile    = 0x8
+	CPIOTypeSymlink = 0xa
+	CPIOTypeSocket  = 0xc
+
+	maxSymlinkSize = 4096
+)
+
+// MaxFileSize is the biggest entry accepted, it is set by the config.
+var MaxFileSize int64 = 16 << 30
+
 type CPIOFileMeta struct {
 	Name         string
 	MD5          string
+	SHA256       string
+	HdrMD5       string
 	OriginalName string
 
+	// LinkTo is the target of symlink.
+	LinkTo string
+
 	CPIOFileHeader
 }
 
 // return 1. new name, 2. path to save file, 3. whether calc md5
 type CPIOPreCheck func(string, *CPIOFileHeader) (string, string, bool, error)
 
-func ReceiveCpioFiles(resp io.Reader, check CPIOPreCheck) ([]CPIOFileMeta, error) {
-	r := cpioReceiver{resp, check}
+// ReceiveCpioFiles returns the files received completely
+// even if it fails, so the caller can resume the rest.
+// The digests are computed while the files are written.
+// The entries of directory and symlink are created and returned
+// too, but the special files, such as the device, are skipped.
+func ReceiveCpioFiles(resp io.Reader, check CPIOPreCheck, digests Digest) ([]CPIOFileMeta, error) {
+	r := cpioReceiver{
+		reader:   resp,
+		precheck: check,
+		digests:  digests,
+		symlinks: make(map[string]bool),
+		links:    make(map[int64]*hardlink),
+	}
+
 	return r.do()
 }
 
+type hardlink struct {
+	// file is the one which has the data.
+	file    string
+	pending []string
+}
+
 type cpioReceiver struct {
 	reader   io.Reader
 	precheck CPIOPreCheck
+	digests  Digest
+
+	// symlinks are the names of received symlinks, the
+	// entry under them is rejected to avoid writing outside.
+	symlinks map[string]bool
+
+	// links are the hardlinks grouped by the inode.
+	links map[int64]*hardlink
 }
 
 func (r *cpioReceiver) do() ([]CPIOFileMeta, error) {
@@ -36,18 +85,18 @@ func (r *cpioReceiver) do() ([]CPIOFileMeta, error) {
 		// read header
 		buf, err := utils.ReadData(r.reader, 110)
 		if err != nil {
-			return nil, fmt.Errorf("read cpio file header, err: %s", err.Error())
+			return metas, fmt.Errorf("read cpio file header, err: %w", err)
 		}
 
 		header := &CPIOFileHeader{}
 		if err := header.extract(buf); err != nil {
-			return nil, fmt.Errorf("extract cpio file header, err: %s", err.Error())
+			return metas, fmt.Errorf("extract cpio file header, err: %s", err.Error())
 		}
 
 		// read file name
 		buf, err = utils.ReadData(r.reader, header.GetNameStreamSize())
 		if err != nil {
-			return nil, fmt.Errorf("read cpio file name, err: %s", err.Error())
+			return metas, fmt.Errorf("read cpio file name, err: %w", err)
 		}
 
 		name := string(buf[:header.Namesize])
@@ -55,10 +104,14 @@ func (r *cpioReceiver) do() ([]CPIOFileMeta, error) {
 		if header.Size == 0 && name == "TRAILER!!!" {
 			break
 		}
-		name = strings.TrimPrefix(name, "./")
 
-		if name == "." || name == ".." {
-			return nil, fmt.Errorf("cpio filename is %s", name)
+		name, err = r.checkName(name)
+		if err != nil {
+			return metas, err
+		}
+
+		if err := checkSize(header); err != nil {
+			return metas, fmt.Errorf("cpio file:%s, err: %s", name, err.Error())
 		}
 
 		meta := CPIOFileMeta{
@@ -69,69 +122,311 @@ func (r *cpioReceiver) do() ([]CPIOFileMeta, error) {
 		// pre check
 		name, saveTo, calcMD5, err := r.precheck(name, header)
 		if err != nil {
-			return nil, err
+			return metas, err
 		}
 
-		if name == "" {
-			err := utils.EmptyRead(r.reader, header.GetFileStreamSize())
-			if err != nil {
-				return nil, fmt.Errorf("empty read cpio file, err: %s", err.Error())
+		typ := header.GetType()
+
+		if name == "" || !isSupportedType(typ) {
+			if name != "" {
+				utils.LogInfo("skip the special cpio file:%s, type:%x", name, typ)
+			}
+
+			if err := r.skip(header); err != nil {
+				return metas, fmt.Errorf("empty read cpio file, err: %w", err)
 			}
 
 			continue
 		}
 		meta.Name = name
 
-		v, err := r.handleCPIOFile(header, saveTo, calcMD5)
-		if err != nil {
-			return nil, fmt.Errorf("handle cpio file, err: %s", err.Error())
+		if err := r.handleEntry(header, saveTo, calcMD5, &meta); err != nil {
+			return metas, fmt.Errorf("handle cpio file, err: %w", err)
+		}
+
+		if typ == CPIOTypeSymlink {
+			r.symlinks[meta.OriginalName] = true
 		}
-		meta.MD5 = v
 
 		metas = append(metas, meta)
 	}
 
+	if err := r.finishHardlinks(); err != nil {
+		return metas, fmt.Errorf("handle cpio hardlinks, err: %s", err.Error())
+	}
+
 	return metas, nil
 }
 
-func (r *cpioReceiver) handleCPIOFile(header *CPIOFileHeader, saveTo string, calcMD5 bool) (string, error) {
-	if saveTo != "" {
-		if err := r.saveCPIOFile(header, saveTo); err != nil {
-			return "", err
+// checkName rejects the name which is outside of the target dir,
+// including the one under a received symlink.
+func (r *cpioReceiver) checkName(name string) (string, error) {
+	if name == "" || strings.ContainsRune(name, 0) {
+		return "", fmt.Errorf("invalid cpio filename:%q", name)
+	}
+
+	if path.IsAbs(name) {
+		return "", fmt.Errorf("cpio filename is absolute path:%s", name)
+	}
+
+	v := path.Clean(name)
+	if v == "." || v == ".." || strings.HasPrefix(v, "../") {
+		return "", fmt.Errorf("cpio filename is %s", name)
+	}
+
+	for p := path.Dir(v); p != "."; p = path.Dir(p) {
+		if r.symlinks[p] {
+			return "", fmt.Errorf("cpio filename:%s is under the symlink:%s", name, p)
 		}
+	}
+
+	return v, nil
+}
+
+// checkLink rejects the target of symlink which is absolute or outside of
+// the target dir. The ".." is only allowed at the beginning of target,
+// otherwise it may go back through another symlink.
+func checkLink(name, target string) error {
+	if target == "" || strings.ContainsRune(target, 0) {
+		return fmt.Errorf("invalid target of symlink:%s", name)
+	}
+
+	if path.IsAbs(target) {
+		return fmt.Errorf("symlink:%s points to the absolute path:%s", name, target)
+	}
 
-		if calcMD5 {
-			return utils.GenMd5OfFile(saveTo)
+	depth := 0
+	if dir := path.Dir(name); dir != "." {
+		depth = strings.Count(dir, "/") + 1
+	}
+
+	down := false
+
+	for _, item := range strings.Split(target, "/") {
+		switch item {
+		case "", ".":
+
+		case "..":
+			if depth--; depth < 0 || down {
+				return fmt.Errorf("symlink:%s points outside of the dir:%s", name, target)
+			}
+
+		default:
+			down = true
 		}
+	}
+
+	return nil
+}
 
-		return "", nil
+func checkSize(header *CPIOFileHeader) error {
+	if header.Size > MaxFileSize {
+		return fmt.Errorf("too big size:%d", header.Size)
 	}
 
-	v, err := utils.GenMd5OfByteStream(r.reader, header.Size)
-	if err != nil {
-		return v, err
+	switch header.GetType() {
+	case CPIOTypeSymlink:
+		if header.Size == 0 || header.Size > maxSymlinkSize {
+			return fmt.Errorf("invalid size of symlink:%d", header.Size)
+		}
+
+	case CPIOTypeDir:
+		if header.Size != 0 {
+			return fmt.Errorf("invalid size of directory:%d", header.Size)
+		}
 	}
 
-	n := header.GetPad()
-	if n == 0 {
-		return v, nil
+	return nil
+}
+
+func isSupportedType(typ int) bool {
+	return typ == CPIOTypeFile || typ == CPIOTypeDir || typ == CPIOTypeSymlink
+}
+
+func (r *cpioReceiver) skip(header *CPIOFileHeader) error {
+	return utils.EmptyRead(r.reader, header.GetFileStreamSize())
+}
+
+func (r *cpioReceiver) readPad(header *CPIOFileHeader) error {
+	if n := header.GetPad(); n > 0 {
+		_, err := utils.ReadData(r.reader, n)
+
+		return err
 	}
 
-	_, err = utils.ReadData(r.reader, n)
-	return v, err
+	return nil
 }
 
-func (r *cpioReceiver) saveCPIOFile(header *CPIOFileHeader, file string) error {
-	err := utils.DownloadFileWithSize(r.reader, header.Size, file)
+func (r *cpioReceiver) handleEntry(
+	header *CPIOFileHeader, saveTo string, calcMD5 bool, meta *CPIOFileMeta,
+) error {
+	switch header.GetType() {
+	case CPIOTypeDir:
+		if saveTo == "" {
+			return nil
+		}
+
+		if err := removeIfNotDir(saveTo); err != nil {
+			return err
+		}
+
+		return os.MkdirAll(saveTo, 0755)
+
+	case CPIOTypeSymlink:
+		return r.handleSymlink(header, saveTo, meta)
+	}
+
+	// the inode is 0 if the sender doesn't support the hardlink.
+	if header.Nlink > 1 && header.Ino != 0 && saveTo != "" {
+		if done, err := r.handleHardlink(header, saveTo); done || err != nil {
+			return err
+		}
+	}
+
+	return r.handleCPIOFile(header, saveTo, calcMD5, meta)
+}
+
+func (r *cpioReceiver) handleSymlink(header *CPIOFileHeader, saveTo string, meta *CPIOFileMeta) error {
+	buf, err := utils.ReadData(r.reader, int(header.Size))
 	if err != nil {
 		return err
 	}
 
-	if n := header.GetPad(); n > 0 {
-		_, err := utils.ReadData(r.reader, n)
+	if err := r.readPad(header); err != nil {
+		return err
+	}
+
+	meta.LinkTo = string(buf)
+
+	if err := checkLink(meta.OriginalName, meta.LinkTo); err != nil {
+		return err
+	}
+
+	if saveTo == "" {
+		return nil
+	}
 
+	if err := prepare(saveTo); err != nil {
 		return err
 	}
 
+	if err := removeIfNotDir(saveTo); err != nil {
+		return err
+	}
+
+	return os.Symlink(meta.LinkTo, saveTo)
+}
+
+// handleHardlink returns true if the entry is done. In the newc format,
+// the data is carried by the last entry of the links, the others are
+// empty and created when the data arrives.
+func (r *cpioReceiver) handleHardlink(header *CPIOFileHeader, saveTo string) (bool, error) {
+	l := r.links[header.Ino]
+	if l == nil {
+		l = new(hardlink)
+		r.links[header.Ino] = l
+	}
+
+	if l.file != "" {
+		if err := r.skip(header); err != nil {
+			return true, err
+		}
+
+		return true, link(l.file, saveTo)
+	}
+
+	if header.Size == 0 {
+		l.pending = append(l.pending, saveTo)
+
+		return true, nil
+	}
+
+	l.file = saveTo
+
+	return false, nil
+}
+
+// finishHardlinks creates the links after all the entries are received.
+// The links whose data is not sent are empty files.
+func (r *cpioReceiver) finishHardlinks() error {
+	for _, l := range r.links {
+		pending := l.pending
+
+		if l.file == "" {
+			if len(pending) == 0 {
+				continue
+			}
+
+			if err := utils.DownloadFile(strings.NewReader(""), pending[0]); err != nil {
+				return err
+			}
+
+			l.file, pending = pending[0], pending[1:]
+		}
+
+		for _, f := range pending {
+			if err := link(l.file, f); err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
+
+func (r *cpioReceiver) handleCPIOFile(
+	header *CPIOFileHeader, saveTo string, calcMD5 bool, meta *CPIOFileMeta,
+) error {
+	d := newDigester(calcMD5 || saveTo == "", r.digests)
+
+	if saveTo != "" {
+		if err := prepare(saveTo); err != nil {
+			return err
+		}
+
+		err := utils.DownloadFileWithSizeTee(r.reader, header.Size, saveTo, d.writer())
+		if err != nil {
+			return err
+		}
+	} else {
+		if err := utils.TeeRead(r.reader, header.Size, d.writer()); err != nil {
+			return err
+		}
+	}
+
+	d.set(meta)
+
+	return r.readPad(header)
+}
+
+// prepare creates the parent dir and removes the existing file, so
+// the file is not written to the place which the old symlink points to.
+func prepare(f string) error {
+	if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+		return err
+	}
+
+	if v, err := os.Lstat(f); err == nil && v.Mode()&os.ModeSymlink != 0 {
+		return os.Remove(f)
+	}
+
+	return nil
+}
+
+func removeIfNotDir(f string) error {
+	if v, err := os.Lstat(f); err == nil && !v.IsDir() {
+		return os.Remove(f)
+	}
+
+	return nil
+}
+
+func link(src, dst string) error {
+	if err := prepare(dst); err != nil {
+		return err
+	}
+
+	os.Remove(dst)
+
+	return os.Link(src, dst)
+}
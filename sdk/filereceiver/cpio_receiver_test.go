@@ -0,0 +1,163 @@
+package filereceiver
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckLink(t *testing.T) {
+	cases := []struct {
+		name   string
+		target string
+		ok     bool
+	}{
+		{"a", "b", true},
+		{"a", "./b/c", true},
+		{"d/a", "../b", true},
+		{"d/e/a", "../../b", true},
+		{"a", "", false},
+		{"a", "/etc/passwd", false},
+		{"a", "..", false},
+		{"a", "../b", false},
+		{"d/a", "../../b", false},
+		{"d/a", "b/../../c", false},
+		// it may go back through the symlink of b.
+		{"a", "b/../c", false},
+	}
+
+	for _, c := range cases {
+		if err := checkLink(c.name, c.target); (err == nil) != c.ok {
+			t.Errorf("%s -> %s, got err:%v", c.name, c.target, err)
+		}
+	}
+}
+
+func cpioEntry(mode int64, name, data string) string {
+	s := EncodeHeader(CPIOFileHeader{Mode: mode, Size: int64(len(data))}, name) + data
+
+	return s + strings.Repeat("\x00", (4-len(data)&3)&3)
+}
+
+func receiveTo(dir string, data []byte) ([]CPIOFileMeta, error) {
+	check := func(name string, h *CPIOFileHeader) (string, string, bool, error) {
+		return name, filepath.Join(dir, name), true, nil
+	}
+
+	return ReceiveCpioFiles(bytes.NewReader(data), check, DigestHdrMD5)
+}
+
+func TestReceiveRejectsLinkOutside(t *testing.T) {
+	dir := t.TempDir()
+
+	data := cpioEntry(0120777, "a", "/etc") + EncodeEmpty()
+	if _, err := receiveTo(dir, []byte(data)); err == nil {
+		t.Fatal("received the symlink to absolute path")
+	}
+
+	data = cpioEntry(0040755, "d", "") + cpioEntry(0120777, "d/a", "../..") + EncodeEmpty()
+	if _, err := receiveTo(dir, []byte(data)); err == nil {
+		t.Fatal("received the symlink to the parent of dir")
+	}
+
+	if _, err := os.Lstat(filepath.Join(dir, "d", "a")); !os.IsNotExist(err) {
+		t.Fatal("the symlink is created")
+	}
+}
+
+func TestReceiveDigests(t *testing.T) {
+	rpm := "\xed\xab\xee\xdb" + strings.Repeat("\x00", 92) +
+		"\x8e\xad\xe8\x01\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x10" +
+		"\x00\x00\x03\xec\x00\x00\x00\x07\x00\x00\x00\x00\x00\x00\x00\x10" +
+		strings.Repeat("\x01", 16) + "payload"
+	data := []byte(cpioEntry(0100644, "a.rpm", rpm) + cpioEntry(0100644, "b", "data") + EncodeEmpty())
+
+	check := func(name string, h *CPIOFileHeader) (string, string, bool, error) {
+		return name, "", true, nil
+	}
+
+	for _, d := range []Digest{DigestHdrMD5, DigestHdrMD5 | DigestSHA256} {
+		metas, err := ReceiveCpioFiles(bytes.NewReader(data), check, d)
+		if err != nil || len(metas) != 2 {
+			t.Fatalf("digests:%d, got %v, err:%v", d, metas, err)
+		}
+
+		for i, v := range []string{rpm, "data"} {
+			m := &metas[i]
+
+			if m.MD5 != fmt.Sprintf("%x", md5.Sum([]byte(v))) {
+				t.Errorf("digests:%d, %s got md5:%s", d, m.Name, m.MD5)
+			}
+
+			sha := ""
+			if d&DigestSHA256 != 0 {
+				sha = fmt.Sprintf("%x", sha256.Sum256([]byte(v)))
+			}
+
+			if m.SHA256 != sha {
+				t.Errorf("digests:%d, %s got sha256:%s", d, m.Name, m.SHA256)
+			}
+		}
+
+		if v := metas[0].HdrMD5; v != strings.Repeat("01", 16) {
+			t.Errorf("digests:%d, got hdrmd5:%s", d, v)
+		}
+
+		if v := metas[1].HdrMD5; v != "" {
+			t.Errorf("digests:%d, got hdrmd5 of non-rpm:%s", d, v)
+		}
+	}
+}
+
+// FuzzReceiveCpioFiles checks nothing is written outside of the dir.
+func FuzzReceiveCpioFiles(f *testing.F) {
+	f.Add([]byte(cpioEntry(0100644, "a", "data") + EncodeEmpty()))
+	f.Add([]byte(
+		cpioEntry(0040755, "d", "") + cpioEntry(0120777, "d/l", "../a") +
+			cpioEntry(0120777, "l", ".") + cpioEntry(0100644, "d/l/x", "data") +
+			EncodeEmpty(),
+	))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		root := t.TempDir()
+		dir := filepath.Join(root, "recv")
+
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		receiveTo(dir, data)
+
+		items, err := os.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != 1 {
+			t.Fatalf("written outside of the dir:%v", items)
+		}
+
+		filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err != nil || info.Mode()&os.ModeSymlink == 0 {
+				return nil
+			}
+
+			target, err := os.Readlink(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			v := filepath.Join(filepath.Dir(p), target)
+			if v != dir && !strings.HasPrefix(v, dir+"/") {
+				t.Fatalf("symlink:%s points outside:%s", p, target)
+			}
+
+			return nil
+		})
+	})
+}
@@ -0,0 +1,235 @@
+package filereceiver
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Digest are the digests computed while the files are received.
+type Digest int
+
+const (
+	// DigestHdrMD5 extracts the md5 of rpm header and payload
+	// from the signature header, it is empty if not a rpm.
+	DigestHdrMD5 Digest = 1 << iota
+
+	// DigestSHA256 computes the sha256 of file, it is not needed by
+	// the backend of OBS, so it is computed only if asked.
+	DigestSHA256
+)
+
+const (
+	rpmLeadSize     = 96
+	rpmSigTagMD5    = 1004
+	maxRpmSigHeader = 1 << 20
+)
+
+type digester struct {
+	writers []io.Writer
+
+	md5    hash.Hash
+	sha256 hash.Hash
+	hdrmd5 *hdrmd5Writer
+}
+
+func newDigester(calcMD5 bool, d Digest) *digester {
+	r := new(digester)
+
+	if calcMD5 {
+		r.md5 = md5.New()
+		r.writers = append(r.writers, r.md5)
+	}
+
+	if d&DigestSHA256 != 0 {
+		r.sha256 = sha256.New()
+		r.writers = append(r.writers, r.sha256)
+	}
+
+	if d&DigestHdrMD5 != 0 {
+		r.hdrmd5 = new(hdrmd5Writer)
+		r.writers = append(r.writers, r.hdrmd5)
+	}
+
+	return r
+}
+
+func (d *digester) writer() io.Writer {
+	if len(d.writers) == 0 {
+		return nil
+	}
+
+	return io.MultiWriter(d.writers...)
+}
+
+func (d *digester) set(meta *CPIOFileMeta) {
+	if d.md5 != nil {
+		meta.MD5 = fmt.Sprintf("%x", d.md5.Sum(nil))
+	}
+
+	if d.sha256 != nil {
+		meta.SHA256 = fmt.Sprintf("%x", d.sha256.Sum(nil))
+	}
+
+	if d.hdrmd5 != nil {
+		meta.HdrMD5 = d.hdrmd5.result
+	}
+}
+
+// hdrmd5Writer keeps the beginning of a rpm until the signature header
+// is complete, then it gets the md5 from the header and ignores the rest.
+type hdrmd5Writer struct {
+	buf    []byte
+	done   bool
+	result string
+}
+
+func (w *hdrmd5Writer) Write(p []byte) (int, error) {
+	if w.done {
+		return len(p), nil
+	}
+
+	w.buf = append(w.buf, p...)
+
+	w.parse()
+
+	return len(p), nil
+}
+
+func (w *hdrmd5Writer) parse() {
+	buf := w.buf
+
+	if len(buf) >= 4 && string(buf[:4]) != "\xed\xab\xee\xdb" {
+		w.finish("")
+
+		return
+	}
+
+	// lead + the intro of signature header
+	start := rpmLeadSize + 16
+	if len(buf) < start {
+		return
+	}
+
+	h := buf[rpmLeadSize:]
+	if string(h[:3]) != "\x8e\xad\xe8" {
+		w.finish("")
+
+		return
+	}
+
+	n := int(binary.BigEndian.Uint32(h[8:12]))
+	size := int(binary.BigEndian.Uint32(h[12:16]))
+
+	total := start + n*16 + size
+	if n <= 0 || size < 0 || total > maxRpmSigHeader {
+		w.finish("")
+
+		return
+	}
+
+	if len(buf) < total {
+		return
+	}
+
+	w.finish(sigMD5(buf[start:total], n))
+}
+
+// sigMD5 finds the md5 in the index and store of signature header.
+func sigMD5(sig []byte, n int) string {
+	store := sig[n*16:]
+
+	for i := 0; i < n; i++ {
+		entry := sig[i*16 : i*16+16]
+
+		if binary.BigEndian.Uint32(entry[:4]) != rpmSigTagMD5 {
+			continue
+		}
+
+		offset := int(binary.BigEndian.Uint32(entry[8:12]))
+		if offset+16 <= len(store) {
+			return fmt.Sprintf("%x", store[offset:offset+16])
+		}
+	}
+
+	return ""
+}
+
+func (w *hdrmd5Writer) finish(v string) {
+	w.done = true
+	w.result = v
+	w.buf = nil
+}
+
+// HdrMD5 returns the hdrmd5 of the rpm read from r, it is empty if not a rpm.
+func HdrMD5(r io.Reader) (string, error) {
+	w := new(hdrmd5Writer)
+	buf := make([]byte, 1<<15)
+
+	for !w.done {
+		n, err := r.Read(buf)
+		if n > 0 {
+			w.Write(buf[:n])
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return w.result, nil
+}
+
+// CheckHdrMD5 reads the whole rpm and checks the md5 of its header and
+// payload against the one in the signature header. It returns the hdrmd5
+// which is empty if not a rpm or no md5 in the signature header.
+func CheckHdrMD5(r io.Reader) (string, error) {
+	intro := make([]byte, rpmLeadSize+16)
+	if _, err := io.ReadFull(r, intro); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	h := intro[rpmLeadSize:]
+	if string(intro[:4]) != "\xed\xab\xee\xdb" || string(h[:3]) != "\x8e\xad\xe8" {
+		return "", nil
+	}
+
+	n := int(binary.BigEndian.Uint32(h[8:12]))
+	size := int(binary.BigEndian.Uint32(h[12:16]))
+	if n <= 0 || size < 0 || n*16+size > maxRpmSigHeader {
+		return "", fmt.Errorf("invalid signature header")
+	}
+
+	// the signature header is padded to 8 bytes.
+	sig := make([]byte, n*16+size+(8-size%8)%8)
+	if _, err := io.ReadFull(r, sig); err != nil {
+		return "", fmt.Errorf("read signature header, err:%s", err.Error())
+	}
+
+	v := sigMD5(sig, n)
+	if v == "" {
+		return "", nil
+	}
+
+	m := md5.New()
+	if _, err := io.Copy(m, r); err != nil {
+		return "", err
+	}
+
+	if s := fmt.Sprintf("%x", m.Sum(nil)); s != v {
+		return v, fmt.Errorf("hdrmd5 mismatch, expect:%s, got:%s", v, s)
+	}
+
+	return v, nil
+}
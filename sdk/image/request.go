@@ -2,17 +2,26 @@ package image
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/zengchen1024/obs-worker/sdk/filereceiver"
 	"github.com/zengchen1024/obs-worker/utils"
 )
 
+const (
+	maxResumeFailures = 3
+	resumeBackoff     = 500 * time.Millisecond
+)
+
 type QueryOpts struct {
 	Prpa []string `json:"-"` // value is project/repository/arch
 }
@@ -29,7 +38,7 @@ func (l *QueryOpts) toQuery() (string, error) {
 	return q.Encode(), nil
 }
 
-func Post(endpoint string, opts *QueryOpts, data []byte, workDir string) (images []Image, err error) {
+func Post(ctx context.Context, endpoint string, opts *QueryOpts, data []byte) (images []Image, err error) {
 	q, err := opts.toQuery()
 	if err != nil {
 		return
@@ -40,7 +49,7 @@ func Post(endpoint string, opts *QueryOpts, data []byte, workDir string) (images
 		return
 	}
 
-	req, err := http.NewRequest(http.MethodPost, urlStr, bytes.NewReader(data))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, urlStr, bytes.NewReader(data))
 	if err != nil {
 		return
 	}
@@ -48,20 +57,12 @@ func Post(endpoint string, opts *QueryOpts, data []byte, workDir string) (images
 	req.Header.Set("Content-Type", "application/octet-stream")
 
 	handle := func(h http.Header, r io.Reader) error {
-		f, err := ioutil.TempFile(workDir, "getpreinstallimageinfos")
+		v, err := ioutil.ReadAll(r)
 		if err != nil {
 			return err
 		}
 
-		defer os.Remove(f.Name())
-
-		_, err = io.Copy(f, r)
-		f.Close()
-		if err != nil {
-			return err
-		}
-
-		images, err = extract(f.Name(), workDir)
+		images, err = extract(v)
 
 		return err
 	}
@@ -71,20 +72,160 @@ func Post(endpoint string, opts *QueryOpts, data []byte, workDir string) (images
 	return
 }
 
-func Download(endpoint, prpa, path, saveTo string) error {
+// Download resumes the download with HTTP Range after the transfer is
+// interrupted by the server or network. It stops at the other errors, such
+// as the 4xx responses. The finished file is checked with the size the
+// server declares and with md5 if it is not empty.
+func Download(ctx context.Context, endpoint, prpa, path, saveTo, md5 string) error {
 	urlStr, err := utils.GenURL(endpoint+fmt.Sprintf("/build/%s/%s", prpa, path), "")
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest(http.MethodGet, urlStr, nil)
+	if err = resume(ctx, urlStr, saveTo); err == nil {
+		err = check(saveTo, md5)
+	}
+
+	if err != nil {
+		os.Remove(saveTo)
+	}
+
+	return err
+}
+
+func resume(ctx context.Context, urlStr, saveTo string) error {
+	offset := int64(0)
+
+	for failures := 0; ; {
+		size, err := download(ctx, urlStr, saveTo, offset)
+		if err == nil {
+			return checkSize(saveTo, size)
+		}
+
+		if ctx.Err() != nil || !isResumable(err) {
+			return err
+		}
+
+		// the file is written from the beginning if the range is ignored.
+		n := int64(0)
+		if v, err := os.Stat(saveTo); err == nil {
+			n = v.Size()
+		}
+
+		if isStatus(err, http.StatusRequestedRangeNotSatisfiable) {
+			n = 0
+		}
+
+		if n > offset {
+			failures = 0
+		} else if failures++; failures > maxResumeFailures {
+			return err
+		}
+
+		utils.LogInfo("resume downloading %s from %d, err:%s", urlStr, n, err.Error())
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(resumeBackoff << uint(failures)):
+		}
+
+		offset = n
+	}
+}
+
+// isResumable returns true if the error may be gone by retrying.
+// 416 means the offset is invalid, it restarts from the beginning.
+func isResumable(err error) bool {
+	var e *utils.StatusError
+	if errors.As(err, &e) {
+		switch e.Code {
+		case http.StatusRequestedRangeNotSatisfiable,
+			http.StatusRequestTimeout, http.StatusTooManyRequests:
+			return true
+		}
+	}
+
+	return utils.IsServerError(err)
+}
+
+func isStatus(err error, code int) bool {
+	var e *utils.StatusError
+
+	return errors.As(err, &e) && e.Code == code
+}
+
+func checkSize(file string, size int64) error {
+	if size < 0 {
+		return nil
+	}
+
+	v, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	if v.Size() != size {
+		return fmt.Errorf("the size of %s is %d, but expect %d", file, v.Size(), size)
+	}
+
+	return nil
+}
+
+func check(file, md5 string) error {
+	if md5 == "" {
+		return nil
+	}
+
+	v, err := utils.GenMd5OfFile(file)
 	if err != nil {
 		return err
 	}
 
+	if v != md5 {
+		return fmt.Errorf("the md5 of %s is %s, but expect %s", file, v, md5)
+	}
+
+	return nil
+}
+
+// download returns the size of whole file, it is -1 if unknown.
+func download(ctx context.Context, urlStr, saveTo string, offset int64) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	// the offset is of the identity, so it must not be compressed.
+	req.Header.Set("Accept-Encoding", utils.EncodingIdentity)
+
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	size := int64(-1)
+
 	handle := func(h http.Header, r io.Reader) error {
-		return filereceiver.ReceiveFile(h, r, saveTo)
+		// the server may ignore the range and send the whole file.
+		cr := h.Get("Content-Range")
+		if !strings.HasPrefix(cr, fmt.Sprintf("bytes %d-", offset)) {
+			offset = 0
+		}
+
+		if offset == 0 {
+			if v, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64); err == nil {
+				size = v
+			}
+		} else if i := strings.LastIndex(cr, "/"); i >= 0 {
+			if v, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+				size = v
+			}
+		}
+
+		return utils.ResumeFile(r, saveTo, offset)
 	}
 
-	return utils.ForwardTo(req, handle)
+	err = utils.ForwardTo(req, handle)
+
+	return size, err
 }
@@ -0,0 +1,167 @@
+package image
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// imageServer serves the image and breaks the first response halfway.
+// The nth request is responded with codes[n] if it is set.
+type imageServer struct {
+	*httptest.Server
+
+	data   []byte
+	code   int
+	codes  map[int]int
+	lock   sync.Mutex
+	ranges []string
+}
+
+func newImageServer(data []byte, code int) *imageServer {
+	s := &imageServer{data: data, code: code}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+		n := len(s.ranges)
+		s.lock.Unlock()
+
+		code := s.code
+		if v, ok := s.codes[n]; ok {
+			code = v
+		}
+
+		if code != 0 {
+			http.Error(w, http.StatusText(code), code)
+
+			return
+		}
+
+		if n == 1 {
+			w.Header().Set("Content-Length", fmt.Sprint(len(s.data)))
+			w.Write(s.data[:len(s.data)/2])
+			w.(http.Flusher).Flush()
+
+			panic(http.ErrAbortHandler)
+		}
+
+		http.ServeContent(w, r, "image", time.Time{}, bytes.NewReader(s.data))
+	}))
+
+	return s
+}
+
+func (s *imageServer) getRanges() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]string{}, s.ranges...)
+}
+
+func testImage() []byte {
+	return []byte(strings.Repeat("preinstall image\n", 1<<12))
+}
+
+func TestDownloadResume(t *testing.T) {
+	data := testImage()
+
+	s := newImageServer(data, 0)
+	defer s.Close()
+
+	f := filepath.Join(t.TempDir(), "image")
+	sum := fmt.Sprintf("%x", md5.Sum(data))
+
+	if err := Download(context.Background(), s.URL, "prj/repo/x86_64", "img", f, sum); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := os.ReadFile(f); err != nil || !bytes.Equal(b, data) {
+		t.Fatalf("the downloaded file is wrong, err:%v", err)
+	}
+
+	// it resumes from where the first response is broken.
+	if v := s.getRanges(); len(v) != 2 || v[0] != "" || v[1] == "" || v[1] == "bytes=0-" {
+		t.Fatalf("got ranges:%q", v)
+	}
+}
+
+func TestDownloadStopOnClientError(t *testing.T) {
+	s := newImageServer(testImage(), http.StatusNotFound)
+	defer s.Close()
+
+	f := filepath.Join(t.TempDir(), "image")
+
+	if err := Download(context.Background(), s.URL, "prj/repo/x86_64", "img", f, ""); err == nil {
+		t.Fatal("expect error")
+	}
+
+	if v := s.getRanges(); len(v) != 1 {
+		t.Fatalf("retried on 404, requests:%d", len(v))
+	}
+
+	if _, err := os.Stat(f); !os.IsNotExist(err) {
+		t.Fatal("the file is not removed")
+	}
+}
+
+func TestDownloadChecksumMismatch(t *testing.T) {
+	s := newImageServer(testImage(), 0)
+	defer s.Close()
+
+	f := filepath.Join(t.TempDir(), "image")
+	sum := fmt.Sprintf("%x", md5.Sum([]byte("other")))
+
+	err := Download(context.Background(), s.URL, "prj/repo/x86_64", "img", f, sum)
+	if err == nil || !strings.Contains(err.Error(), "md5") {
+		t.Fatalf("expect md5 mismatch, got:%v", err)
+	}
+
+	if _, err := os.Stat(f); !os.IsNotExist(err) {
+		t.Fatal("the file is not removed")
+	}
+}
+
+func testResume(t *testing.T, codes map[int]int) []string {
+	data := testImage()
+
+	s := newImageServer(data, 0)
+	defer s.Close()
+
+	s.codes = codes
+
+	f := filepath.Join(t.TempDir(), "image")
+	sum := fmt.Sprintf("%x", md5.Sum(data))
+
+	if err := Download(context.Background(), s.URL, "prj/repo/x86_64", "img", f, sum); err != nil {
+		t.Fatal(err)
+	}
+
+	return s.getRanges()
+}
+
+func TestDownloadResumeWithoutProgress(t *testing.T) {
+	v := testResume(t, map[int]int{2: http.StatusServiceUnavailable})
+
+	// it resumes from the same offset instead of the beginning.
+	if len(v) != 3 || v[1] == "" || v[1] == "bytes=0-" || v[2] != v[1] {
+		t.Fatalf("got ranges:%q", v)
+	}
+}
+
+func TestDownloadRestartOnInvalidRange(t *testing.T) {
+	v := testResume(t, map[int]int{2: http.StatusRequestedRangeNotSatisfiable})
+
+	if len(v) != 3 || v[1] == "" || v[2] != "" {
+		t.Fatalf("got ranges:%q", v)
+	}
+}
@@ -1,14 +1,15 @@
 package image
 
 import (
+	"bytes"
 	"fmt"
-	"path/filepath"
 
-	"sigs.k8s.io/yaml"
-
-	"github.com/zengchen1024/obs-worker/utils"
+	"github.com/zengchen1024/obs-worker/sdk/storable"
 )
 
+// magic is the prefix of the Storable data responded by the server.
+const magic = "pst0"
+
 type Image struct {
 	SizeK   int      `json:"sizek"`
 	Prpa    string   `json:"prpa"`
@@ -17,25 +18,63 @@ type Image struct {
 	HdrMD5  string   `json:"hdrmd5"`
 	Package string   `json:"package"`
 	HdrMD5s []string `json:"hdrmd5s"`
+
+	// MD5 is the md5 of image file, it is empty if not provided.
+	MD5 string `json:"md5"`
 }
 
-func extract(input string, workDir string) ([]Image, error) {
-	var images struct {
-		Images []Image `json:"images"`
+// extract parses the response which is empty if no image.
+func extract(data []byte) ([]Image, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
 	}
 
-	v, err, _ := utils.RunCmd(
-		"perl", "-I", filepath.Join(workDir, "perl"),
-		filepath.Join(workDir, "perl", "parse_image_info.pm"),
-		input,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("%s, %v", v, err)
+	if !bytes.HasPrefix(data, []byte(magic)) {
+		return nil, fmt.Errorf("the image infos are not Storable data")
 	}
 
-	if err = yaml.Unmarshal(v, &images); err != nil {
+	v, err := storable.Unmarshal(data)
+	if err != nil {
 		return nil, err
 	}
 
-	return images.Images, nil
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the image infos are not an array")
+	}
+
+	images := make([]Image, 0, len(items))
+
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("the image info is not a hash")
+		}
+
+		sizek, err := storable.Int(m["sizek"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid sizek, err:%s", err.Error())
+		}
+
+		v := Image{
+			SizeK:   int(sizek),
+			Prpa:    storable.String(m["prpa"]),
+			File:    storable.String(m["file"]),
+			Path:    storable.String(m["path"]),
+			HdrMD5:  storable.String(m["hdrmd5"]),
+			MD5:     storable.String(m["md5"]),
+			Package: storable.String(m["package"]),
+		}
+
+		if md5s, ok := m["hdrmd5s"].([]interface{}); ok {
+			v.HdrMD5s = make([]string, len(md5s))
+			for i := range md5s {
+				v.HdrMD5s[i] = storable.String(md5s[i])
+			}
+		}
+
+		images = append(images, v)
+	}
+
+	return images, nil
 }
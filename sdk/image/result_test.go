@@ -0,0 +1,61 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	// testdata/imageinfos is written by testdata/gen.pl.
+	b, err := os.ReadFile(filepath.Join("testdata", "imageinfos"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := extract(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Image{
+		{
+			SizeK:   102400,
+			Prpa:    "openEuler:Mainline/standard_x86_64/x86_64",
+			File:    "preinstallimage.tar.zst",
+			Path:    "x86_64/:preinstallimages/bash/preinstallimage.tar.zst",
+			HdrMD5:  "0123456789abcdef0123456789abcdef",
+			Package: "preinstallimage",
+			HdrMD5s: []string{
+				"00112233445566778899aabbccddeeff",
+				"ffeeddccbbaa99887766554433221100",
+			},
+		},
+		{
+			SizeK:   3221225472,
+			Prpa:    "openEuler:Mainline/standard_x86_64/x86_64",
+			File:    "other.tar.gz",
+			Path:    "x86_64/:preinstallimages/other/other.tar.gz",
+			HdrMD5:  "fedcba9876543210fedcba9876543210",
+			Package: "other",
+			HdrMD5s: []string{},
+		},
+	}
+
+	if !reflect.DeepEqual(v, want) {
+		t.Fatalf("got %+v, want %+v", v, want)
+	}
+}
+
+func TestExtractEmpty(t *testing.T) {
+	for _, data := range []string{"", "\n"} {
+		if v, err := extract([]byte(data)); err != nil || len(v) != 0 {
+			t.Fatalf("extract %q, got %v, err:%v", data, v, err)
+		}
+	}
+
+	if _, err := extract([]byte("<images/>")); err == nil {
+		t.Fatal("extract the data which is not Storable without error")
+	}
+}
@@ -0,0 +1,31 @@
+#!/usr/bin/perl
+# Generates the response of getpreinstallimageinfos as the repo server of
+# OBS does with BSUtil::tostorable, run it in this dir.
+use strict;
+use warnings;
+use Storable qw(nfreeze);
+
+my $images = [
+  {
+    'prpa' => 'openEuler:Mainline/standard_x86_64/x86_64',
+    'file' => 'preinstallimage.tar.zst',
+    'path' => 'x86_64/:preinstallimages/bash/preinstallimage.tar.zst',
+    'package' => 'preinstallimage',
+    'hdrmd5' => '0123456789abcdef0123456789abcdef',
+    'sizek' => 102400,
+    'hdrmd5s' => ['00112233445566778899aabbccddeeff', 'ffeeddccbbaa99887766554433221100'],
+  },
+  {
+    'prpa' => 'openEuler:Mainline/standard_x86_64/x86_64',
+    'file' => 'other.tar.gz',
+    'path' => 'x86_64/:preinstallimages/other/other.tar.gz',
+    'package' => 'other',
+    'hdrmd5' => 'fedcba9876543210fedcba9876543210',
+    'sizek' => '3221225472',
+    'hdrmd5s' => [],
+  },
+];
+
+open(my $f, '>', 'imageinfos') || die;
+print $f 'pst0' . nfreeze($images);
+close($f);
@@ -0,0 +1,144 @@
+package job
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/zengchen1024/obs-worker/sdk/filereceiver"
+	"github.com/zengchen1024/obs-worker/utils"
+)
+
+// Entry is an entry of the cpio stream uploaded.
+type Entry struct {
+	Name   string
+	Path   string
+	Type   int
+	Perm   uint32
+	Mtime  int64
+	Size   int64
+	LinkTo string
+
+	// MD5 is the md5 of regular file which is computed when it is uploaded.
+	MD5 string
+}
+
+func (e *Entry) header() string {
+	return filereceiver.EncodeHeader(
+		filereceiver.CPIOFileHeader{
+			Mode:  int64(e.Type<<12) | int64(e.Perm),
+			Mtime: e.Mtime,
+			Size:  e.Size,
+		},
+		e.Name,
+	)
+}
+
+func (e *Entry) pad() int {
+	return int(4-(e.Size&3)) & 3
+}
+
+// Manifest is the entries of the cpio stream which are computed before
+// uploading. The files are checked against it when they are uploaded.
+type Manifest struct {
+	Entries []Entry
+
+	// Size is the length of the cpio stream.
+	Size int64
+}
+
+// NewManifest walks the files. The directory is uploaded with all the
+// files, directories and symlinks under it, and the special files are
+// skipped. The names of entries under it are the relative paths.
+func NewManifest(files []File) (*Manifest, error) {
+	m := new(Manifest)
+
+	for i := range files {
+		f := &files[i]
+
+		info, err := os.Stat(f.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			m.add(f.Name, f.Path, info)
+
+			continue
+		}
+
+		if err := m.walk(f.Name, f.Path); err != nil {
+			return nil, err
+		}
+	}
+
+	m.Size += int64(len(filereceiver.EncodeEmpty()))
+
+	return m, nil
+}
+
+func (m *Manifest) walk(name, dir string) error {
+	// the tree is walked from where the symlink points to.
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		v := name
+		if rel != "." {
+			v = path.Join(name, filepath.ToSlash(rel))
+		}
+
+		m.add(v, p, info)
+
+		return nil
+	})
+}
+
+func (m *Manifest) add(name, p string, info os.FileInfo) {
+	e := Entry{
+		Name:  name,
+		Path:  p,
+		Perm:  uint32(info.Mode().Perm()),
+		Mtime: info.ModTime().Unix(),
+	}
+
+	switch mode := info.Mode(); {
+	case mode.IsRegular():
+		e.Type = filereceiver.CPIOTypeFile
+		e.Size = info.Size()
+
+	case mode.IsDir():
+		e.Type = filereceiver.CPIOTypeDir
+
+	case mode&os.ModeSymlink != 0:
+		v, err := os.Readlink(p)
+		if err != nil {
+			utils.LogErr("read symlink:%s, err:%s", p, err.Error())
+
+			return
+		}
+
+		e.Type = filereceiver.CPIOTypeSymlink
+		e.Size = int64(len(v))
+		e.LinkTo = v
+
+	default:
+		utils.LogInfo("skip the special file:%s", p)
+
+		return
+	}
+
+	m.Entries = append(m.Entries, e)
+	m.Size += int64(len(e.header())) + e.Size + int64(e.pad())
+}
@@ -0,0 +1,193 @@
+package job
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/zengchen1024/obs-worker/sdk/filereceiver"
+	"github.com/zengchen1024/obs-worker/utils"
+)
+
+// receivedEntry is the entry received by the server.
+type receivedEntry struct {
+	Name   string
+	Mode   int64
+	Size   int64
+	MD5    string
+	LinkTo string
+}
+
+// cpioServer receives the cpio stream as the repo server does.
+type cpioServer struct {
+	*httptest.Server
+
+	entries []receivedEntry
+	length  int64
+}
+
+func newCPIOServer(t *testing.T) *cpioServer {
+	s := &cpioServer{}
+	dir := t.TempDir()
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.length = r.ContentLength
+
+		check := func(name string, h *filereceiver.CPIOFileHeader) (string, string, bool, error) {
+			return name, filepath.Join(dir, name), true, nil
+		}
+
+		metas, err := filereceiver.ReceiveCpioFiles(r.Body, check, 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		for i := range metas {
+			m := &metas[i]
+
+			s.entries = append(s.entries, receivedEntry{
+				Name:   m.Name,
+				Mode:   m.Mode,
+				Size:   m.Size,
+				MD5:    m.MD5,
+				LinkTo: m.LinkTo,
+			})
+		}
+	}))
+
+	return s
+}
+
+func writeTree(t *testing.T, dir string, files map[string]string, perms map[string]os.FileMode) {
+	for name, data := range files {
+		f := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(f, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, perm := range perms {
+		f := filepath.Join(dir, name)
+
+		// it is a dir if it is not a file.
+		if _, ok := files[name]; !ok {
+			if err := os.MkdirAll(f, perm); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// it is not changed by umask.
+		if err := os.Chmod(f, perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func md5Of(data string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(data)))
+}
+
+func TestPutTree(t *testing.T) {
+	s := newCPIOServer(t)
+	defer s.Close()
+
+	cfg := utils.HTTPClientConfig{}
+	cfg.SetDefault()
+
+	if err := utils.InitHTTPClient(&cfg, &utils.ClientTLSConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	tree := filepath.Join(dir, "tree")
+
+	writeTree(t, tree, map[string]string{
+		"image.packages": "packages",
+		"boot/grub.cfg":  "grub",
+		"boot/run.sh":    "#!/bin/sh\n",
+	}, map[string]os.FileMode{
+		".":           0755,
+		"boot":        0755,
+		"boot/empty":  0700,
+		"boot/run.sh": 0755,
+	})
+
+	if err := os.Symlink("boot/grub.cfg", filepath.Join(tree, "grub.cfg")); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTree(t, dir, map[string]string{"_log": "log"}, nil)
+
+	m, err := NewManifest([]File{
+		{Name: "_log", Path: filepath.Join(dir, "_log")},
+		{Name: "KIWI", Path: tree},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Opts{Job: "job", Arch: "x86_64", JobId: "1", Code: "succeeded", KiwiTree: true}
+	if err := PutManifest(context.Background(), s.URL, opts, m); err != nil {
+		t.Fatal(err)
+	}
+
+	file := func(name, data string, perm int64) receivedEntry {
+		return receivedEntry{
+			Name: name, Mode: filereceiver.CPIOTypeFile<<12 | perm,
+			Size: int64(len(data)), MD5: md5Of(data),
+		}
+	}
+
+	dirEntry := func(name string, perm int64) receivedEntry {
+		return receivedEntry{Name: name, Mode: filereceiver.CPIOTypeDir<<12 | perm}
+	}
+
+	want := []receivedEntry{
+		file("_log", "log", 0644),
+		dirEntry("KIWI", 0755),
+		dirEntry("KIWI/boot", 0755),
+		dirEntry("KIWI/boot/empty", 0700),
+		file("KIWI/boot/grub.cfg", "grub", 0644),
+		file("KIWI/boot/run.sh", "#!/bin/sh\n", 0755),
+		{
+			Name: "KIWI/grub.cfg", Mode: filereceiver.CPIOTypeSymlink<<12 | 0777,
+			Size: int64(len("boot/grub.cfg")), LinkTo: "boot/grub.cfg",
+		},
+		file("KIWI/image.packages", "packages", 0644),
+	}
+
+	if !reflect.DeepEqual(s.entries, want) {
+		t.Fatalf("got entries:\n%+v\nwant:\n%+v", s.entries, want)
+	}
+
+	// the manifest is what is sent.
+	if s.length != m.Size {
+		t.Fatalf("the size of manifest is %d, but %d is sent", m.Size, s.length)
+	}
+
+	if len(m.Entries) != len(want) {
+		t.Fatalf("got %d entries of manifest", len(m.Entries))
+	}
+
+	for i := range m.Entries {
+		e, w := &m.Entries[i], &want[i]
+
+		if e.Name != w.Name || int64(e.Type<<12)|int64(e.Perm) != w.Mode ||
+			e.Size != w.Size || e.MD5 != w.MD5 || e.LinkTo != w.LinkTo {
+			t.Errorf("got entry of manifest:%+v, want:%+v", e, w)
+		}
+	}
+}
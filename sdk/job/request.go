@@ -2,7 +2,9 @@ package job
 
 import (
 	"context"
+	"crypto/md5"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,6 +12,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/zengchen1024/obs-worker/sdk/filereceiver"
@@ -51,12 +54,24 @@ func (o *Opts) toQuery() (string, error) {
 	return q.Encode(), nil
 }
 
+// File is a file or directory to upload. The directory is
+// uploaded with the whole tree under it.
 type File struct {
-	Name string
-	Path string
+	Name string `json:"name"`
+	Path string `json:"path"`
 }
 
-func Put(endpoint string, opts Opts, files []File) (err error) {
+func Put(ctx context.Context, endpoint string, opts Opts, files []File) error {
+	m, err := NewManifest(files)
+	if err != nil {
+		return err
+	}
+
+	return PutManifest(ctx, endpoint, opts, m)
+}
+
+// PutManifest uploads the entries of manifest and sets the md5 of them.
+func PutManifest(ctx context.Context, endpoint string, opts Opts, m *Manifest) (err error) {
 	s, err := opts.toQuery()
 	if err != nil {
 		return
@@ -67,48 +82,127 @@ func Put(endpoint string, opts Opts, files []File) (err error) {
 		return
 	}
 
-	return upload(urlStr, files)
+	encoding := utils.ContentEncoding(urlStr)
+	if v, ok := serverEncodings.Load(endpoint); ok {
+		encoding = v.(string)
+	}
+
+	tried := map[string]bool{}
+
+	for {
+		tried[encoding] = true
+
+		err = upload(ctx, urlStr, m, encoding)
+
+		next, ok := fallbackEncoding(err, encoding, tried)
+		if !ok {
+			break
+		}
+
+		utils.LogInfo(
+			"%s may not support the encoding:%s, upload with %s, err:%s",
+			endpoint, encoding, next, err.Error(),
+		)
+
+		encoding = next
+	}
+
+	// it is recorded only if the server accepts it, so a transient
+	// error of server doesn't disable the encoding.
+	if err == nil && len(tried) > 1 {
+		serverEncodings.Store(endpoint, encoding)
+	}
+
+	return
 }
 
-func upload(urlStr string, files []File) error {
-	ctx, cancel := context.WithCancel(context.Background())
+// serverEncodings records the encoding of upload negotiated with each server.
+var serverEncodings sync.Map
+
+// fallbackEncoding returns the encoding to retry with if the server rejects
+// the encoding. It prefers the ones in the Accept-Encoding of response.
+// The backend of OBS responds the unknown Content-Encoding with 400 or 500
+// rather than 415.
+func fallbackEncoding(err error, encoding string, tried map[string]bool) (string, bool) {
+	var e *utils.StatusError
+	if encoding == "" || encoding == utils.EncodingIdentity || !errors.As(err, &e) {
+		return "", false
+	}
+
+	switch e.Code {
+	case http.StatusBadRequest, http.StatusUnsupportedMediaType, http.StatusInternalServerError:
+	default:
+		return "", false
+	}
+
+	for _, v := range utils.ParseAcceptEncoding(e.Header) {
+		if utils.IsValidEncoding(v) && !tried[v] {
+			return v, true
+		}
+	}
+
+	return utils.EncodingIdentity, true
+}
+
+func upload(ctx context.Context, urlStr string, m *Manifest, encoding string) error {
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	r, w := io.Pipe()
 
 	defer r.Close()
 
+	ew, err := utils.NewEncoder(w, encoding)
+	if err != nil {
+		return err
+	}
+
 	go func() {
 		// must close here, otherwise the http request will be blocked.
 		// because the read will be done when the writer is done.
 		// when the read is done, then the http request can continue.
-		defer w.Close()
+		// the request fails with the error, rather than
+		// sending an incomplete stream.
+		err := write(ctx, ew, m)
+		if err == nil {
+			if err = ew.Close(); err != nil {
+				utils.LogErr("flush the encoded data failed, err:%s", err.Error())
+			}
+		}
 
-		write(ctx, w, files)
+		w.CloseWithError(err)
 	}()
 
-	req, err := http.NewRequest(http.MethodPost, urlStr, r)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, urlStr, r)
 	if err != nil {
 		return err
 	}
 
-	req.Header.Set("Transfer-Encoding", "chunked")
 	req.Header.Set("Content-Type", "application/x-cpio")
 
+	if encoding != "" && encoding != utils.EncodingIdentity {
+		req.Header.Set("Content-Encoding", encoding)
+	} else {
+		// the length is known, so the server can check the stream.
+		req.ContentLength = m.Size
+	}
+
 	return utils.ForwardTo(req, nil)
 }
 
-func write(ctx context.Context, w io.Writer, files []File) {
+func write(ctx context.Context, w io.Writer, m *Manifest) error {
 	r := newReader()
 
-	for _, file := range files {
-		if err := r.readFile(ctx, w, file); err != nil {
+	for i := range m.Entries {
+		e := &m.Entries[i]
+
+		if err := r.writeEntry(ctx, w, e); err != nil {
 			utils.LogErr(
 				"write failed cpio file%s, err:%s",
-				file.Path, err.Error(),
+				e.Path, err.Error(),
 			)
 
-			return
+			return err
 		}
 	}
 
@@ -116,7 +210,11 @@ func write(ctx context.Context, w io.Writer, files []File) {
 
 	if err := utils.Write(ctx, w, []byte(s)); err != nil {
 		utils.LogErr("write the last chunk failed, err:%s", err.Error())
+
+		return err
 	}
+
+	return nil
 }
 
 func newReader() (r reader) {
@@ -140,31 +238,42 @@ type reader struct {
 	total int64
 }
 
-func (r *reader) readFile(ctx context.Context, w io.Writer, file File) error {
-	info, err := os.Stat(file.Path)
-	if err != nil {
-		return err
+func (r *reader) writeEntry(ctx context.Context, w io.Writer, e *Entry) error {
+	h := e.header()
+	if len(h) >= r.size {
+		return fmt.Errorf("maybe too long file name")
 	}
 
-	h, pad := filereceiver.Encode(info, file.Name, nil)
+	switch e.Type {
+	case filereceiver.CPIOTypeDir:
+		return utils.Write(ctx, w, []byte(h))
 
-	r.pad = pad
-	r.total = info.Size()
+	case filereceiver.CPIOTypeSymlink:
+		s := h + e.LinkTo + strings.Repeat("\x00", e.pad())
 
-	fi, err := os.Open(file.Path)
+		return utils.Write(ctx, w, []byte(s))
+	}
+
+	return r.readFile(ctx, w, e, h)
+}
+
+func (r *reader) readFile(ctx context.Context, w io.Writer, e *Entry, h string) error {
+	r.pad = e.pad()
+	r.total = e.Size
+
+	fi, err := os.Open(e.Path)
 	if err != nil {
 		return err
 	}
 
 	defer fi.Close()
 
-	if len(h) >= r.size {
-		return fmt.Errorf("maybe too long file name")
-	}
+	hash := md5.New()
+	fr := io.TeeReader(fi, hash)
 
 	copy(r.buf[r.start:], h)
 
-	n, err := r.read(ctx, fi, r.start+len(h))
+	n, err := r.read(ctx, fr, r.start+len(h))
 	if err != nil {
 		return err
 	}
@@ -174,7 +283,7 @@ func (r *reader) readFile(ctx context.Context, w io.Writer, file File) error {
 	}
 
 	for r.total > 0 {
-		n, err := r.read(ctx, fi, r.start)
+		n, err := r.read(ctx, fr, r.start)
 		if err != nil {
 			return err
 		}
@@ -184,17 +293,33 @@ func (r *reader) readFile(ctx context.Context, w io.Writer, file File) error {
 		}
 	}
 
+	// the size in the header is sent, so it must not be changed.
+	if info, err := fi.Stat(); err != nil || info.Size() != e.Size {
+		return fmt.Errorf("%s is changed when it is uploaded", e.Path)
+	}
+
+	e.MD5 = fmt.Sprintf("%x", hash.Sum(nil))
+
 	return nil
 }
 
 func (r *reader) read(ctx context.Context, fi io.Reader, start int) (int, error) {
 	offset := start
 
-	n, err := utils.ReadTo(ctx, fi, r.buf[offset:r.end])
+	end := r.end
+	if v := int64(end - offset); v > r.total {
+		end = offset + int(r.total)
+	}
+
+	n, err := utils.ReadTo(ctx, fi, r.buf[offset:end])
 	if err != nil {
 		return 0, err
 	}
 
+	if n < end-offset {
+		return 0, fmt.Errorf("the file is truncated")
+	}
+
 	r.total -= int64(n)
 	offset += n
 
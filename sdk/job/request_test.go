@@ -0,0 +1,99 @@
+package job
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/zengchen1024/obs-worker/utils"
+)
+
+// encodingServer rejects the encoded body with code as the backend of OBS
+// does, and records the encodings of requests.
+type encodingServer struct {
+	*httptest.Server
+
+	code      int
+	lock      sync.Mutex
+	encodings []string
+}
+
+func newEncodingServer(code int) *encodingServer {
+	s := &encodingServer{code: code}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := r.Header.Get("Content-Encoding")
+
+		s.lock.Lock()
+		s.encodings = append(s.encodings, e)
+		s.lock.Unlock()
+
+		io.Copy(ioutil.Discard, r.Body)
+
+		if e != "" && e != utils.EncodingIdentity {
+			http.Error(w, "unsupported Content-Encoding", s.code)
+
+			return
+		}
+	}))
+
+	return s
+}
+
+func (s *encodingServer) getEncodings() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]string{}, s.encodings...)
+}
+
+func testPut(t *testing.T, code int) {
+	s := newEncodingServer(code)
+	defer s.Close()
+
+	cfg := utils.HTTPClientConfig{
+		HTTPOptions: utils.HTTPOptions{ContentEncoding: utils.EncodingGzip},
+	}
+	cfg.SetDefault()
+	cfg.RetryOnStatus = []int{}
+
+	if err := utils.InitHTTPClient(&cfg, &utils.ClientTLSConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	f := filepath.Join(t.TempDir(), "_log")
+	if err := ioutil.WriteFile(f, []byte("log"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Opts{Job: "job", Arch: "x86_64", JobId: "1", Code: "succeeded"}
+	files := []File{{Name: "_log", Path: f}}
+
+	for i := 0; i < 2; i++ {
+		if err := Put(context.Background(), s.URL, opts, files); err != nil {
+			t.Fatalf("code:%d, err:%v", code, err)
+		}
+	}
+
+	// the second upload uses the encoding negotiated by the first one.
+	want := []string{utils.EncodingGzip, "", ""}
+	if v := s.getEncodings(); !reflect.DeepEqual(v, want) {
+		t.Fatalf("code:%d, got encodings:%q", code, v)
+	}
+}
+
+func TestPutFallbackEncoding(t *testing.T) {
+	for _, code := range []int{
+		http.StatusBadRequest,
+		http.StatusUnsupportedMediaType,
+		http.StatusInternalServerError,
+	} {
+		testPut(t, code)
+	}
+}
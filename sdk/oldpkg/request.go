@@ -1,6 +1,7 @@
 package oldpkg
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -17,7 +18,7 @@ type ListOpts struct {
 }
 
 func List(
-	endpoint string, opts *ListOpts,
+	ctx context.Context, endpoint string, opts *ListOpts,
 	check filereceiver.CPIOPreCheck,
 ) (meta []filereceiver.CPIOFileMeta, err error) {
 	p := map[string]string{
@@ -39,13 +40,13 @@ func List(
 		return nil, err
 	}
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	handle := func(h http.Header, resp io.Reader) error {
-		meta, err = filereceiver.ReceiveCpioFiles(resp, check)
+		meta, err = filereceiver.ReceiveCpioFiles(resp, check, 0)
 
 		return err
 	}
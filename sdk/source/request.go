@@ -1,6 +1,7 @@
 package source
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -26,7 +27,7 @@ func (l *ListOpts) toMap() (map[string]string, error) {
 	return v, err
 }
 
-func List(endpoint string, opts *ListOpts, check filereceiver.CPIOPreCheck) (meta []filereceiver.CPIOFileMeta, err error) {
+func List(ctx context.Context, endpoint string, opts *ListOpts, check filereceiver.CPIOPreCheck) (meta []filereceiver.CPIOFileMeta, err error) {
 	p, err := opts.toMap()
 	if err != nil {
 		return nil, err
@@ -37,13 +38,13 @@ func List(endpoint string, opts *ListOpts, check filereceiver.CPIOPreCheck) (met
 		return nil, err
 	}
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	handle := func(h http.Header, resp io.Reader) error {
-		meta, err = filereceiver.ReceiveCpioFiles(resp, check)
+		meta, err = filereceiver.ReceiveCpioFiles(resp, check, 0)
 
 		return err
 	}
@@ -1,6 +1,7 @@
 package sslcert
 
 import (
+	"context"
 	"io"
 	"net/http"
 
@@ -8,7 +9,7 @@ import (
 	"github.com/zengchen1024/obs-worker/utils"
 )
 
-func List(endpoint, project string, autoExtend bool, saveTo string) error {
+func List(ctx context.Context, endpoint, project string, autoExtend bool, saveTo string) error {
 	p := map[string]string{
 		"project": project,
 	}
@@ -21,7 +22,7 @@ func List(endpoint, project string, autoExtend bool, saveTo string) error {
 		return err
 	}
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return err
 	}
@@ -58,6 +58,16 @@ type Download struct {
 	Cachehits       int    `xml:"cachehits"`
 	Binaries        int    `xml:"binaries"`
 	PreinstallImage string `xml:"preinstallimage"`
+
+	// Servers are the servers which the files are downloaded from.
+	Servers []Server `xml:"server,omitempty"`
+}
+
+type Server struct {
+	XMLName xml.Name `xml:"server"`
+
+	Kind string `xml:"kind,attr"`
+	URL  string `xml:",chardata"`
 }
 
 type Time struct {
@@ -0,0 +1,523 @@
+// Package storable reads and writes the subset of Perl Storable used by
+// OBS, such as the cache content file of worker and the responses of
+// getpreinstallimageinfos.
+//
+// The values are decoded as nil, string, int64, float64, bool,
+// []interface{} and map[string]interface{}. The references are
+// transparent and the blessed objects are decoded as the plain ones.
+package storable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const (
+	magic = "pst0"
+
+	binMajor = 2
+	binMinor = 11
+)
+
+const (
+	sxObject      = 0
+	sxLScalar     = 1
+	sxArray       = 2
+	sxHash        = 3
+	sxRef         = 4
+	sxUndef       = 5
+	sxInteger     = 6
+	sxDouble      = 7
+	sxByte        = 8
+	sxNetint      = 9
+	sxScalar      = 10
+	sxSVUndef     = 14
+	sxSVYes       = 15
+	sxSVNo        = 16
+	sxBless       = 17
+	sxIXBless     = 18
+	sxUTF8Str     = 23
+	sxLUTF8Str    = 24
+	sxFlagHash    = 25
+	sxSVUndefElem = 31
+)
+
+// shvKIsSV is the flag of key in the flag hash which means the key is stored as a scalar.
+const shvKIsSV = 0x08
+
+// maxDepth limits the nesting of data to avoid exhausting the stack.
+const maxDepth = 1024
+
+// Unmarshal decodes the data written by nstore, store, nfreeze or freeze.
+// The leading magic of file is optional, so both the content of file and
+// the frozen data prefixed with "pst0" are accepted.
+func Unmarshal(data []byte) (interface{}, error) {
+	d := decoder{data: data}
+
+	if len(data) >= len(magic) && string(data[:len(magic)]) == magic {
+		d.pos = len(magic)
+	}
+
+	if err := d.header(); err != nil {
+		return nil, fmt.Errorf("invalid storable header, err:%s", err.Error())
+	}
+
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("decode storable at %d, err:%s", d.pos, err.Error())
+	}
+
+	return v, nil
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+
+	// order is the byte order of lengths and native integers.
+	order    binary.ByteOrder
+	netorder bool
+	ivSize   int
+	nvSize   int
+
+	seen    []interface{}
+	done    []bool
+	classes []string
+}
+
+func (d *decoder) header() error {
+	b, err := d.byte()
+	if err != nil {
+		return err
+	}
+
+	if major := int(b >> 1); major != binMajor {
+		return fmt.Errorf("unsupported major version:%d", major)
+	}
+
+	if _, err := d.byte(); err != nil {
+		return err
+	}
+
+	if d.netorder = b&1 == 1; d.netorder {
+		d.order = binary.BigEndian
+
+		return nil
+	}
+
+	n, err := d.byte()
+	if err != nil {
+		return err
+	}
+
+	s, err := d.read(int(n))
+	if err != nil {
+		return err
+	}
+
+	switch string(s) {
+	case "12345678", "1234":
+		d.order = binary.LittleEndian
+	case "87654321", "4321":
+		d.order = binary.BigEndian
+	default:
+		return fmt.Errorf("unsupported byte order:%q", s)
+	}
+
+	d.ivSize = len(s)
+
+	// the sizes of int, long, pointer and NV
+	sizes, err := d.read(4)
+	if err != nil {
+		return err
+	}
+
+	if d.nvSize = int(sizes[3]); d.nvSize != 8 {
+		return fmt.Errorf("unsupported size of double:%d", d.nvSize)
+	}
+
+	return nil
+}
+
+func (d *decoder) byte() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, fmt.Errorf("unexpected end of data")
+	}
+
+	b := d.data[d.pos]
+	d.pos++
+
+	return b, nil
+}
+
+func (d *decoder) read(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data)-d.pos {
+		return nil, fmt.Errorf("unexpected end of data, want %d bytes", n)
+	}
+
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+
+	return b, nil
+}
+
+// length reads the length of string, array and hash.
+func (d *decoder) length() (int, error) {
+	b, err := d.read(4)
+	if err != nil {
+		return 0, err
+	}
+
+	n := int32(d.order.Uint32(b))
+	if n < 0 {
+		return 0, fmt.Errorf("invalid length:%d", n)
+	}
+
+	return int(n), nil
+}
+
+func (d *decoder) string(long bool) (string, error) {
+	n := 0
+
+	if long {
+		v, err := d.length()
+		if err != nil {
+			return "", err
+		}
+
+		n = v
+	} else {
+		b, err := d.byte()
+		if err != nil {
+			return "", err
+		}
+
+		n = int(b)
+	}
+
+	b, err := d.read(n)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// see registers the object in order, it can be referred to by the later
+// SX_OBJECT before it is decoded completely.
+func (d *decoder) see() int {
+	d.seen = append(d.seen, nil)
+	d.done = append(d.done, false)
+
+	return len(d.seen) - 1
+}
+
+func (d *decoder) set(i int, v interface{}) interface{} {
+	d.seen[i] = v
+	d.done[i] = true
+
+	return v
+}
+
+func (d *decoder) decode(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("too deep nesting")
+	}
+
+	op, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch op {
+	case sxObject:
+		return d.object()
+
+	case sxBless, sxIXBless:
+		if err := d.bless(op); err != nil {
+			return nil, err
+		}
+
+		return d.decode(depth + 1)
+
+	case sxRef:
+		i := d.see()
+
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		return d.set(i, v), nil
+
+	case sxArray:
+		return d.array(depth)
+
+	case sxHash, sxFlagHash:
+		return d.hash(op == sxFlagHash, depth)
+	}
+
+	i := d.see()
+
+	v, err := d.scalar(op)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.set(i, v), nil
+}
+
+func (d *decoder) object() (interface{}, error) {
+	b, err := d.read(4)
+	if err != nil {
+		return nil, err
+	}
+
+	// the tag is always in the network order.
+	tag := int(binary.BigEndian.Uint32(b))
+	if tag >= len(d.seen) {
+		return nil, fmt.Errorf("invalid object tag:%d", tag)
+	}
+
+	if !d.done[tag] {
+		return nil, fmt.Errorf("recursive object tag:%d is unsupported", tag)
+	}
+
+	return d.seen[tag], nil
+}
+
+// bless reads the class name which is dropped.
+func (d *decoder) bless(op byte) error {
+	b, err := d.byte()
+	if err != nil {
+		return err
+	}
+
+	long := b&0x80 != 0
+
+	if op == sxIXBless {
+		i := int(b)
+		if long {
+			if i, err = d.length(); err != nil {
+				return err
+			}
+		}
+
+		if i >= len(d.classes) {
+			return fmt.Errorf("invalid class index:%d", i)
+		}
+
+		return nil
+	}
+
+	n := int(b)
+	if long {
+		if n, err = d.length(); err != nil {
+			return err
+		}
+	}
+
+	s, err := d.read(n)
+	if err != nil {
+		return err
+	}
+
+	d.classes = append(d.classes, string(s))
+
+	return nil
+}
+
+func (d *decoder) array(depth int) (interface{}, error) {
+	i := d.see()
+
+	n, err := d.length()
+	if err != nil {
+		return nil, err
+	}
+
+	// each item is one byte at least.
+	if n > len(d.data)-d.pos {
+		return nil, fmt.Errorf("invalid length of array:%d", n)
+	}
+
+	v := make([]interface{}, n)
+	for j := range v {
+		if v[j], err = d.decode(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+
+	return d.set(i, v), nil
+}
+
+func (d *decoder) hash(flagged bool, depth int) (interface{}, error) {
+	i := d.see()
+
+	if flagged {
+		if _, err := d.byte(); err != nil {
+			return nil, err
+		}
+	}
+
+	n, err := d.length()
+	if err != nil {
+		return nil, err
+	}
+
+	// each item is five bytes at least.
+	if n > len(d.data)-d.pos {
+		return nil, fmt.Errorf("invalid length of hash:%d", n)
+	}
+
+	v := make(map[string]interface{}, n)
+	for j := 0; j < n; j++ {
+		item, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := d.key(flagged, depth)
+		if err != nil {
+			return nil, err
+		}
+
+		v[key] = item
+	}
+
+	return d.set(i, v), nil
+}
+
+func (d *decoder) key(flagged bool, depth int) (string, error) {
+	if flagged {
+		f, err := d.byte()
+		if err != nil {
+			return "", err
+		}
+
+		if f&shvKIsSV != 0 {
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return "", err
+			}
+
+			return String(v), nil
+		}
+	}
+
+	return d.string(true)
+}
+
+func (d *decoder) scalar(op byte) (interface{}, error) {
+	switch op {
+	case sxUndef, sxSVUndef, sxSVUndefElem:
+		return nil, nil
+
+	case sxSVYes:
+		return true, nil
+
+	case sxSVNo:
+		return false, nil
+
+	case sxByte:
+		b, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		return int64(b) - 128, nil
+
+	case sxNetint:
+		b, err := d.read(4)
+		if err != nil {
+			return nil, err
+		}
+
+		return int64(int32(binary.BigEndian.Uint32(b))), nil
+
+	case sxInteger:
+		if d.netorder {
+			return nil, fmt.Errorf("native integer in network order")
+		}
+
+		b, err := d.read(d.ivSize)
+		if err != nil {
+			return nil, err
+		}
+
+		if d.ivSize == 4 {
+			return int64(int32(d.order.Uint32(b))), nil
+		}
+
+		return int64(d.order.Uint64(b)), nil
+
+	case sxDouble:
+		if d.netorder {
+			return nil, fmt.Errorf("native double in network order")
+		}
+
+		b, err := d.read(d.nvSize)
+		if err != nil {
+			return nil, err
+		}
+
+		return math.Float64frombits(d.order.Uint64(b)), nil
+
+	case sxScalar, sxUTF8Str:
+		return d.string(false)
+
+	case sxLScalar, sxLUTF8Str:
+		return d.string(true)
+	}
+
+	return nil, fmt.Errorf("unsupported type:%d", op)
+}
+
+// String returns the value as Perl stringifies the scalar.
+func String(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+
+	case int64:
+		return strconv.FormatInt(x, 10)
+
+	case float64:
+		return strconv.FormatFloat(x, 'g', 15, 64)
+
+	case bool:
+		if x {
+			return "1"
+		}
+	}
+
+	return ""
+}
+
+// Int returns the integer of scalar. The big integer is stored as
+// string in the network order.
+func Int(v interface{}) (int64, error) {
+	switch x := v.(type) {
+	case nil:
+		return 0, nil
+
+	case int64:
+		return x, nil
+
+	case float64:
+		return int64(x), nil
+
+	case bool:
+		if x {
+			return 1, nil
+		}
+
+		return 0, nil
+
+	case string:
+		return strconv.ParseInt(x, 10, 64)
+	}
+
+	return 0, fmt.Errorf("%T is not a scalar", v)
+}
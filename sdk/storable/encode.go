@@ -0,0 +1,181 @@
+package storable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Marshal encodes v in the network order with the leading magic as
+// nstore of Perl does, so the data is the same as the file it writes.
+// v must be an array or a hash, the nested ones are stored as references
+// and the keys of hash are sorted.
+func Marshal(v interface{}) ([]byte, error) {
+	e := encoder{}
+
+	e.WriteString(magic)
+	e.WriteByte(binMajor<<1 | 1)
+	e.WriteByte(binMinor)
+
+	switch v.(type) {
+	case []interface{}, []string, map[string]interface{}, map[string]string:
+	default:
+		return nil, fmt.Errorf("%T can't be stored, it must be an array or a hash", v)
+	}
+
+	if err := e.encode(v, 0); err != nil {
+		return nil, err
+	}
+
+	return e.Bytes(), nil
+}
+
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) length(n int) {
+	var b [4]byte
+
+	binary.BigEndian.PutUint32(b[:], uint32(n))
+	e.Write(b[:])
+}
+
+func (e *encoder) string(s string) {
+	if len(s) <= math.MaxUint8 {
+		e.WriteByte(sxScalar)
+		e.WriteByte(byte(len(s)))
+	} else {
+		e.WriteByte(sxLScalar)
+		e.length(len(s))
+	}
+
+	e.WriteString(s)
+}
+
+func (e *encoder) int(v int64) {
+	switch {
+	case v >= -128 && v <= 127:
+		e.WriteByte(sxByte)
+		e.WriteByte(byte(v + 128))
+
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		var b [4]byte
+
+		binary.BigEndian.PutUint32(b[:], uint32(int32(v)))
+		e.WriteByte(sxNetint)
+		e.Write(b[:])
+
+	default:
+		// it is stored as string if bigger than 32 bits.
+		e.string(String(v))
+	}
+}
+
+func (e *encoder) encode(v interface{}, depth int) error {
+	if depth > maxDepth {
+		return fmt.Errorf("too deep nesting")
+	}
+
+	switch x := v.(type) {
+	case nil:
+		e.WriteByte(sxUndef)
+
+	case string:
+		e.string(x)
+
+	case int:
+		e.int(int64(x))
+
+	case int32:
+		e.int(int64(x))
+
+	case int64:
+		e.int(x)
+
+	case float64:
+		e.string(String(x))
+
+	case bool:
+		if x {
+			e.WriteByte(sxSVYes)
+		} else {
+			e.WriteByte(sxSVNo)
+		}
+
+	case []interface{}:
+		e.WriteByte(sxArray)
+		e.length(len(x))
+
+		for i := range x {
+			if err := e.item(x[i], depth); err != nil {
+				return err
+			}
+		}
+
+	case []string:
+		e.WriteByte(sxArray)
+		e.length(len(x))
+
+		for i := range x {
+			e.string(x[i])
+		}
+
+	case map[string]interface{}:
+		e.WriteByte(sxHash)
+		e.length(len(x))
+
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+
+		for _, k := range sorted(keys) {
+			if err := e.item(x[k], depth); err != nil {
+				return err
+			}
+
+			e.length(len(k))
+			e.WriteString(k)
+		}
+
+	case map[string]string:
+		e.WriteByte(sxHash)
+		e.length(len(x))
+
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+
+		for _, k := range sorted(keys) {
+			e.string(x[k])
+			e.length(len(k))
+			e.WriteString(k)
+		}
+
+	default:
+		return fmt.Errorf("unsupported type:%T", v)
+	}
+
+	return nil
+}
+
+// item encodes the item of array or hash, the nested array
+// or hash is stored as reference.
+func (e *encoder) item(v interface{}, depth int) error {
+	switch v.(type) {
+	case []interface{}, []string, map[string]interface{}, map[string]string:
+		e.WriteByte(sxRef)
+	}
+
+	return e.encode(v, depth+1)
+}
+
+func sorted(keys []string) []string {
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,143 @@
+package storable
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The testdata is written by Storable 3.26 of Perl with testdata/gen.pl.
+
+func readTestdata(t *testing.T, name string) []byte {
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func testContent() []interface{} {
+	return []interface{}{
+		[]interface{}{"0123456789abcdef0123456789abcdef", int64(10)},
+		[]interface{}{"fedcba9876543210fedcba9876543210", int64(300)},
+		[]interface{}{"00112233445566778899aabbccddeeff", int64(70000)},
+		[]interface{}{"ffeeddccbbaa99887766554433221100", "5368709120"},
+	}
+}
+
+func testMixed() map[string]interface{} {
+	shared := []interface{}{"a", "b"}
+
+	return map[string]interface{}{
+		"undef":    nil,
+		"byte":     int64(-100),
+		"int":      int64(123456),
+		"negative": int64(-123456),
+		"double":   1.5,
+		"string":   strings.Repeat("x", 300),
+		"utf8":     "中文",
+		"array":    []interface{}{int64(1), "two", []interface{}{int64(3)}},
+		"shared":   []interface{}{shared, shared},
+		"blessed":  map[string]interface{}{"name": "obj"},
+		"中":        "utf8 key",
+	}
+}
+
+func TestUnmarshalContent(t *testing.T) {
+	v, err := Unmarshal(readTestdata(t, "content.nstore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := testContent(); !reflect.DeepEqual(v, want) {
+		t.Fatalf("got %#v, want %#v", v, want)
+	}
+
+	n, err := Int(v.([]interface{})[3].([]interface{})[1])
+	if err != nil || n != 5368709120 {
+		t.Fatalf("got big integer:%d, err:%v", n, err)
+	}
+}
+
+func TestUnmarshalMixed(t *testing.T) {
+	want := testMixed()
+
+	// the doubles are stored as strings in the network order.
+	netorder := testMixed()
+	netorder["double"] = "1.5"
+
+	for name, want := range map[string]map[string]interface{}{
+		"mixed.nstore": netorder,
+		"mixed.store":  want,
+	} {
+		v, err := Unmarshal(readTestdata(t, name))
+		if err != nil {
+			t.Fatalf("%s, err:%v", name, err)
+		}
+
+		if !reflect.DeepEqual(v, want) {
+			t.Fatalf("%s, got %#v, want %#v", name, v, want)
+		}
+	}
+}
+
+func TestMarshalAsPerl(t *testing.T) {
+	items := []interface{}{
+		[]interface{}{"0123456789abcdef0123456789abcdef", 10},
+		[]interface{}{"fedcba9876543210fedcba9876543210", 300},
+		[]interface{}{"00112233445566778899aabbccddeeff", 70000},
+		[]interface{}{"ffeeddccbbaa99887766554433221100", int64(5368709120)},
+	}
+
+	b, err := Marshal(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := readTestdata(t, "content.nstore"); !bytes.Equal(b, want) {
+		t.Fatalf("got %q, want %q", b, want)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	want := testMixed()
+	want["double"] = "1.5"
+	want["blessed"] = map[string]string{"name": "obj"}
+	want["strings"] = []string{"a", "b"}
+	want["bool"] = true
+
+	b, err := Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := Unmarshal(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want["blessed"] = map[string]interface{}{"name": "obj"}
+	want["strings"] = []interface{}{"a", "b"}
+
+	if !reflect.DeepEqual(v, want) {
+		t.Fatalf("got %#v, want %#v", v, want)
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	data := readTestdata(t, "mixed.nstore")
+
+	for i := 0; i < len(data); i++ {
+		if _, err := Unmarshal(data[:i]); err == nil {
+			t.Fatalf("no error for the data truncated at %d", i)
+		}
+	}
+
+	if _, err := Marshal("scalar"); err == nil {
+		t.Fatal("marshal a scalar without error")
+	}
+}
@@ -0,0 +1,33 @@
+#!/usr/bin/perl
+# Generates the testdata with Storable of Perl, run it in this dir.
+use strict;
+use warnings;
+use Storable qw(nstore store nfreeze);
+
+$Storable::canonical = 1;
+
+# the content of cache of worker.
+nstore([
+  ['0123456789abcdef0123456789abcdef', 10],
+  ['fedcba9876543210fedcba9876543210', 300],
+  ['00112233445566778899aabbccddeeff', 70000],
+  ['ffeeddccbbaa99887766554433221100', 5368709120],
+], 'content.nstore');
+
+my $shared = ['a', 'b'];
+my $data = {
+  'undef' => undef,
+  'byte' => -100,
+  'int' => 123456,
+  'negative' => -123456,
+  'double' => 1.5,
+  'string' => 'x' x 300,
+  'utf8' => "\x{4e2d}\x{6587}",
+  'array' => [1, 'two', [3]],
+  'shared' => [$shared, $shared],
+  'blessed' => bless({'name' => 'obj'}, 'BSObject'),
+  "\x{4e2d}" => 'utf8 key',
+};
+
+nstore($data, 'mixed.nstore');
+store($data, 'mixed.store');
@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	EncodingIdentity = "identity"
+	EncodingGzip     = "gzip"
+	EncodingZstd     = "zstd"
+
+	// acceptEncoding is sent with the requests. The server which
+	// doesn't support them just responds with the identity.
+	acceptEncoding = EncodingZstd + ", " + EncodingGzip
+)
+
+func IsValidEncoding(e string) bool {
+	return e == "" || e == EncodingIdentity || e == EncodingGzip || e == EncodingZstd
+}
+
+// ContentEncoding returns the configured encoding of the request
+// body sent to the url.
+func ContentEncoding(url string) string {
+	return clients.get(url).opts.ContentEncoding
+}
+
+// ParseAcceptEncoding returns the encodings the server accepts which
+// is set in the header of response with status 415.
+func ParseAcceptEncoding(h http.Header) []string {
+	r := []string{}
+
+	for _, item := range strings.Split(h.Get("Accept-Encoding"), ",") {
+		v := strings.TrimSpace(item)
+		if i := strings.Index(v, ";"); i >= 0 {
+			v = strings.TrimSpace(v[:i])
+		}
+
+		if v != "" {
+			r = append(r, strings.ToLower(v))
+		}
+	}
+
+	return r
+}
+
+// NewEncoder returns the writer which encodes the data written to w.
+// The returned writer must be closed to flush the data.
+func NewEncoder(w io.Writer, encoding string) (io.WriteCloser, error) {
+	switch encoding {
+	case "", EncodingIdentity:
+		return nopWriteCloser{w}, nil
+
+	case EncodingGzip:
+		return gzip.NewWriter(w), nil
+
+	case EncodingZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+
+	return nil, fmt.Errorf("unsupported encoding:%s", encoding)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (w nopWriteCloser) Close() error {
+	return nil
+}
+
+// NewDecoder returns the reader which decodes the data read from r.
+// Closing the returned reader doesn't close r.
+func NewDecoder(r io.Reader, encoding string) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", EncodingIdentity:
+		return io.NopCloser(r), nil
+
+	case EncodingGzip:
+		return gzip.NewReader(r)
+
+	case EncodingZstd:
+		v, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+
+		return v.IOReadCloser(), nil
+	}
+
+	return nil, fmt.Errorf("unsupported content encoding:%s", encoding)
+}
+
+// decodeBody replaces the body of response with the decoded one.
+func decodeBody(resp *http.Response) error {
+	e := resp.Header.Get("Content-Encoding")
+	if e == "" || e == EncodingIdentity {
+		return nil
+	}
+
+	body, err := NewDecoder(resp.Body, e)
+	if err != nil {
+		return err
+	}
+
+	resp.Body = &decodedBody{ReadCloser: body, raw: resp.Body}
+
+	// the length is of the encoded data.
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+
+	return nil
+}
+
+type decodedBody struct {
+	io.ReadCloser
+
+	raw io.ReadCloser
+}
+
+func (b *decodedBody) Close() error {
+	b.ReadCloser.Close()
+
+	return b.raw.Close()
+}
@@ -1,8 +1,17 @@
 package utils
 
-import "os/exec"
+import (
+	"os"
+	"os/exec"
+)
 
 func RunCmd(args ...string) ([]byte, error, int) {
+	return RunCmdWithEnv(nil, args...)
+}
+
+// RunCmdWithEnv runs the command with the extra environment
+// variables in the form of "key=value".
+func RunCmdWithEnv(env []string, args ...string) ([]byte, error, int) {
 	n := len(args)
 	if n == 0 {
 		return nil, nil, 0
@@ -17,6 +26,10 @@ func RunCmd(args ...string) ([]byte, error, int) {
 	}
 
 	c := exec.Command(cmd, args...)
+	if len(env) > 0 {
+		c.Env = append(os.Environ(), env...)
+	}
+
 	out, err := c.CombinedOutput()
 	if err == nil {
 		return out, nil, 0
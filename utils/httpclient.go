@@ -4,20 +4,47 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"syscall"
 	"time"
+
+	"github.com/zengchen1024/obs-worker/metrics"
 )
 
-func ForwardTo(req *http.Request, handle func(http.Header, io.Reader) error) error {
+func ForwardTo(req *http.Request, handle func(http.Header, io.Reader) error) (err error) {
+	server, api := requestLabels(req.URL)
+
+	defer func() {
+		if err != nil {
+			metrics.AddRequestError(server, api)
+		}
+	}()
+
+	if req.Body != nil {
+		req.Body = &countReadCloser{req.Body, metrics.AddUploadBytes}
+	}
+
+	// the range is of the identity, so don't negotiate the encoding.
+	if req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+
+	start := time.Now()
+
 	resp, err := sendReq(req)
 	if err != nil || resp == nil {
 		return err
 	}
 
+	metrics.ObserveRequest(server, api, time.Since(start))
+
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
@@ -25,33 +52,137 @@ func ForwardTo(req *http.Request, handle func(http.Header, io.Reader) error) err
 		if err != nil {
 			return err
 		}
-		return fmt.Errorf("response has status:%s and body:%q", resp.Status, rb)
+		return &StatusError{
+			Code:   resp.StatusCode,
+			Status: resp.Status,
+			Body:   rb,
+			Header: resp.Header,
+		}
 	}
 
-	if handle != nil {
-		return handle(resp.Header, resp.Body)
+	if handle == nil {
+		return nil
+	}
+
+	resp.Body = &countReadCloser{resp.Body, metrics.AddDownloadBytes}
+
+	if err := decodeBody(resp); err != nil {
+		return err
 	}
 
-	return nil
+	defer resp.Body.Close()
+
+	return handle(resp.Header, resp.Body)
 }
 
-func sendReq(req *http.Request) (resp *http.Response, err error) {
-	if resp, err = http.DefaultClient.Do(req); err == nil {
-		return
+type StatusError struct {
+	Code   int
+	Status string
+	Body   []byte
+	Header http.Header
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("response has status:%s and body:%q", e.Status, e.Body)
+}
+
+// IsServerError returns true if the server is down or fails to handle the
+// request, which are the network errors and the responses with 5xx.
+func IsServerError(err error) bool {
+	var e *StatusError
+	if errors.As(err, &e) {
+		return e.Code >= 500
 	}
 
-	maxRetries := 3
-	backoff := 10 * time.Millisecond
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	// url.Error is a net.Error too, so check the error it wraps.
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		err = ue.Err
+	}
+
+	var ne net.Error
 
-	for retries := 1; retries < maxRetries; retries++ {
-		time.Sleep(backoff)
-		backoff *= 2
+	return errors.As(err, &ne) || errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// requestLabels returns the server and the api of the request, such as
+// "http://host:5252" and "/getbinaries". The paths of "/build/..." are
+// reduced to "/build" to keep the number of labels small.
+func requestLabels(u *url.URL) (string, string) {
+	api := strings.TrimPrefix(u.Path, "/")
+	if i := strings.Index(api, "/"); i >= 0 {
+		api = api[:i]
+	}
 
-		if resp, err = http.DefaultClient.Do(req); err == nil {
-			break
+	return u.Scheme + "://" + u.Host, "/" + api
+}
+
+type countReadCloser struct {
+	io.ReadCloser
+
+	add func(int64)
+}
+
+func (c *countReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if n > 0 {
+		c.add(int64(n))
+	}
+
+	return n, err
+}
+
+func sendReq(req *http.Request) (resp *http.Response, err error) {
+	c := clients.get(req.URL.String())
+	ctx := req.Context()
+
+	for retries := 0; ; retries++ {
+		resp, err = c.Do(req)
+		if err == nil && !c.retryOn(resp.StatusCode) {
+			return
+		}
+
+		if retries >= c.opts.MaxRetries || !rewindBody(req) {
+			return
+		}
+
+		if err == nil {
+			resp.Body.Close()
 		}
+
+		// the request is canceled, don't retry.
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(c.backoff(retries)):
+		}
+	}
+}
+
+// rewindBody resets the body of request for retrying.
+// It returns false if the body can't be read again.
+func rewindBody(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody {
+		return true
 	}
-	return
+
+	if req.GetBody == nil {
+		return false
+	}
+
+	b, err := req.GetBody()
+	if err != nil {
+		return false
+	}
+
+	req.Body = &countReadCloser{b, metrics.AddUploadBytes}
+
+	return true
 }
 
 func JsonMarshal(t interface{}) ([]byte, error) {
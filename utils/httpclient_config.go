@@ -0,0 +1,353 @@
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HTTPOptions are the settings of the client. The unit of the timeouts
+// is second and the unit of the backoffs is millisecond.
+type HTTPOptions struct {
+	ConnectTimeout int `json:"connect_timeout"`
+
+	// ReadTimeout is the longest time to wait for any data,
+	// including the response header.
+	ReadTimeout int `json:"read_timeout"`
+
+	IdleConnTimeout     int `json:"idle_conn_timeout"`
+	MaxIdleConnsPerHost int `json:"max_idle_conns_per_host"`
+
+	MaxRetries int `json:"max_retries"`
+	Backoff    int `json:"backoff"`
+	MaxBackoff int `json:"max_backoff"`
+
+	// RetryOnStatus are the status codes of response to retry on.
+	RetryOnStatus []int `json:"retry_on_status"`
+
+	// ContentEncoding is the encoding of the uploaded data, gzip or zstd.
+	// It falls back to identity if the server doesn't support it.
+	ContentEncoding string `json:"content_encoding"`
+}
+
+func (o *HTTPOptions) validate() error {
+	v := []int{
+		o.ConnectTimeout, o.ReadTimeout, o.IdleConnTimeout,
+		o.MaxIdleConnsPerHost, o.MaxRetries, o.Backoff, o.MaxBackoff,
+	}
+	for _, i := range v {
+		if i < 0 {
+			return fmt.Errorf("negative value of http client option")
+		}
+	}
+
+	if o.Backoff > o.MaxBackoff && o.MaxBackoff > 0 {
+		return fmt.Errorf("backoff is bigger than max backoff")
+	}
+
+	if !IsValidEncoding(o.ContentEncoding) {
+		return fmt.Errorf("unsupported content encoding:%s", o.ContentEncoding)
+	}
+
+	return nil
+}
+
+// HTTPOverrides are the options of an endpoint. The ones not set inherit
+// the default ones, so they can be overridden with the zero values.
+type HTTPOverrides struct {
+	ConnectTimeout      *int `json:"connect_timeout"`
+	ReadTimeout         *int `json:"read_timeout"`
+	IdleConnTimeout     *int `json:"idle_conn_timeout"`
+	MaxIdleConnsPerHost *int `json:"max_idle_conns_per_host"`
+
+	MaxRetries *int `json:"max_retries"`
+	Backoff    *int `json:"backoff"`
+	MaxBackoff *int `json:"max_backoff"`
+
+	RetryOnStatus   []int   `json:"retry_on_status"`
+	ContentEncoding *string `json:"content_encoding"`
+}
+
+func (o *HTTPOverrides) merge(p *HTTPOptions) HTTPOptions {
+	v := *p
+
+	set := func(dst *int, src *int) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+
+	set(&v.ConnectTimeout, o.ConnectTimeout)
+	set(&v.ReadTimeout, o.ReadTimeout)
+	set(&v.IdleConnTimeout, o.IdleConnTimeout)
+	set(&v.MaxIdleConnsPerHost, o.MaxIdleConnsPerHost)
+	set(&v.MaxRetries, o.MaxRetries)
+	set(&v.Backoff, o.Backoff)
+	set(&v.MaxBackoff, o.MaxBackoff)
+
+	if o.RetryOnStatus != nil {
+		v.RetryOnStatus = o.RetryOnStatus
+	}
+
+	if o.ContentEncoding != nil {
+		v.ContentEncoding = *o.ContentEncoding
+	}
+
+	return v
+}
+
+type HTTPEndpoint struct {
+	HTTPOverrides
+
+	// Prefix is matched with the url of request, such as
+	// "http://backend:5252/getbinaries".
+	Prefix string `json:"prefix" required:"true"`
+
+	// opts are the options merged with the default ones.
+	opts HTTPOptions
+}
+
+type HTTPClientConfig struct {
+	HTTPOptions
+
+	Endpoints []HTTPEndpoint `json:"endpoints"`
+}
+
+func (c *HTTPClientConfig) SetDefault() {
+	if c.ConnectTimeout == 0 {
+		c.ConnectTimeout = 30
+	}
+
+	if c.ReadTimeout == 0 {
+		c.ReadTimeout = 300
+	}
+
+	if c.IdleConnTimeout == 0 {
+		c.IdleConnTimeout = 90
+	}
+
+	if c.MaxIdleConnsPerHost == 0 {
+		c.MaxIdleConnsPerHost = 8
+	}
+
+	if c.MaxRetries == 0 {
+		c.MaxRetries = 2
+	}
+
+	if c.Backoff == 0 {
+		c.Backoff = 10
+	}
+
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = 10000
+	}
+
+	if c.RetryOnStatus == nil {
+		c.RetryOnStatus = []int{
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		}
+	}
+
+	for i := range c.Endpoints {
+		item := &c.Endpoints[i]
+		item.opts = item.merge(&c.HTTPOptions)
+	}
+}
+
+func (c *HTTPClientConfig) Validate() error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	for i := range c.Endpoints {
+		item := &c.Endpoints[i]
+
+		if item.Prefix == "" {
+			return fmt.Errorf("missing prefix of http endpoint")
+		}
+
+		if err := item.opts.validate(); err != nil {
+			return fmt.Errorf("invalid http endpoint:%s, err:%s", item.Prefix, err.Error())
+		}
+	}
+
+	return nil
+}
+
+type httpClient struct {
+	*http.Client
+
+	opts HTTPOptions
+}
+
+func (c *httpClient) backoff(retries int) time.Duration {
+	v := time.Duration(c.opts.Backoff) * time.Millisecond << uint(retries)
+	if m := time.Duration(c.opts.MaxBackoff) * time.Millisecond; m > 0 && (v > m || v <= 0) {
+		v = m
+	}
+
+	return v
+}
+
+func (c *httpClient) retryOn(code int) bool {
+	for _, v := range c.opts.RetryOnStatus {
+		if v == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+type endpointClient struct {
+	prefix string
+	client *httpClient
+}
+
+type httpClients struct {
+	// sorted by the length of prefix in descending order,
+	// so the longest matched prefix is found first.
+	endpoints []endpointClient
+
+	defaultClient *httpClient
+}
+
+func (c *httpClients) get(url string) *httpClient {
+	for i := range c.endpoints {
+		if item := &c.endpoints[i]; strings.HasPrefix(url, item.prefix) {
+			return item.client
+		}
+	}
+
+	return c.defaultClient
+}
+
+var clients = &httpClients{
+	defaultClient: &httpClient{
+		Client: http.DefaultClient,
+		opts:   HTTPOptions{MaxRetries: 2, Backoff: 10},
+	},
+}
+
+// InitHTTPClient sets the clients used to access the repo and
+// source servers. The connections are kept alive and reused.
+func InitHTTPClient(cfg *HTTPClientConfig, tlsCfg *ClientTLSConfig) error {
+	var tc *ClientTLS
+
+	if tlsCfg.Enabled() {
+		v, err := NewClientTLSConfig(tlsCfg)
+		if err != nil {
+			return err
+		}
+
+		tc = v
+	}
+
+	v := &httpClients{
+		defaultClient: newHTTPClient(&cfg.HTTPOptions, tc),
+	}
+
+	for i := range cfg.Endpoints {
+		item := &cfg.Endpoints[i]
+
+		v.endpoints = append(v.endpoints, endpointClient{
+			prefix: item.Prefix,
+			client: newHTTPClient(&item.opts, tc),
+		})
+	}
+
+	sort.SliceStable(v.endpoints, func(i, j int) bool {
+		return len(v.endpoints[i].prefix) > len(v.endpoints[j].prefix)
+	})
+
+	clients = v
+
+	return nil
+}
+
+func newHTTPClient(opts *HTTPOptions, tc *ClientTLS) *httpClient {
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(opts.ConnectTimeout) * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	readTimeout := time.Duration(opts.ReadTimeout) * time.Second
+
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := dialer.DialContext(ctx, network, addr)
+		if err != nil || readTimeout == 0 {
+			return c, err
+		}
+
+		return &timeoutConn{Conn: c, timeout: readTimeout}, nil
+	}
+
+	t := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dial,
+		TLSHandshakeTimeout: dialer.Timeout,
+		IdleConnTimeout:     time.Duration(opts.IdleConnTimeout) * time.Second,
+		MaxIdleConnsPerHost: opts.MaxIdleConnsPerHost,
+		MaxIdleConns:        100,
+	}
+
+	if tc != nil {
+		// it is used by the requests through the proxy.
+		t.TLSClientConfig = tc.Config("")
+
+		// the server is verified with the host dialed, even if it is an IP.
+		t.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				host = addr
+			}
+
+			c, err := dial(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+
+			if dialer.Timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, dialer.Timeout)
+				defer cancel()
+			}
+
+			conn := tls.Client(c, tc.Config(host))
+			if err := conn.HandshakeContext(ctx); err != nil {
+				c.Close()
+
+				return nil, err
+			}
+
+			return conn, nil
+		}
+	}
+
+	return &httpClient{
+		Client: &http.Client{Transport: t},
+		opts:   *opts,
+	}
+}
+
+// timeoutConn fails the read which gets no data in time.
+type timeoutConn struct {
+	net.Conn
+
+	timeout time.Duration
+}
+
+func (c *timeoutConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Read(b)
+}
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEndpointOverridesToZero(t *testing.T) {
+	zero := 0
+	identity := EncodingIdentity
+
+	cfg := HTTPClientConfig{
+		HTTPOptions: HTTPOptions{ContentEncoding: EncodingGzip},
+		Endpoints: []HTTPEndpoint{
+			{
+				Prefix: "http://backend:5252/getbinaries",
+				HTTPOverrides: HTTPOverrides{
+					ReadTimeout:     &zero,
+					MaxRetries:      &zero,
+					RetryOnStatus:   []int{},
+					ContentEncoding: &identity,
+				},
+			},
+			{Prefix: "http://backend:5252/"},
+		},
+	}
+	cfg.SetDefault()
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := cfg.HTTPOptions
+	want.ReadTimeout = 0
+	want.MaxRetries = 0
+	want.RetryOnStatus = []int{}
+	want.ContentEncoding = EncodingIdentity
+
+	if v := cfg.Endpoints[0].opts; !reflect.DeepEqual(v, want) {
+		t.Errorf("got overridden options:%+v", v)
+	}
+
+	// the options not set inherit the default ones.
+	if v := cfg.Endpoints[1].opts; !reflect.DeepEqual(v, cfg.HTTPOptions) {
+		t.Errorf("got inherited options:%+v", v)
+	}
+
+	if err := InitHTTPClient(&cfg, &ClientTLSConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	c := clients.get("http://backend:5252/getbinaries?project=prj")
+	if c.opts.MaxRetries != 0 || c.opts.ReadTimeout != 0 || c.retryOn(502) {
+		t.Errorf("got options of client:%+v", c.opts)
+	}
+}
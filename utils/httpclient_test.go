@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsServerError(t *testing.T) {
+	s := httptest.NewServer(http.NotFoundHandler())
+	addr := s.URL
+	s.Close()
+
+	_, refused := http.Get(addr)
+
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"500", &StatusError{Code: 500}, true},
+		{"503", fmt.Errorf("get: %w", &StatusError{Code: 503}), true},
+		{"404", &StatusError{Code: 404}, false},
+		{"429", &StatusError{Code: 429}, false},
+		{"refused", refused, true},
+		{"unexpected eof", io.ErrUnexpectedEOF, true},
+		{"canceled", context.Canceled, false},
+		{"local", &os.PathError{Op: "open", Path: "x", Err: os.ErrNotExist}, false},
+		{"other", errors.New("invalid cpio header"), false},
+	}
+
+	for _, c := range cases {
+		if v := IsServerError(c.err); v != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, v, c.want)
+		}
+	}
+}
+
+func TestIsServerErrorOfBrokenBody(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("broken"))
+	}))
+	defer s.Close()
+
+	req, err := http.NewRequest(http.MethodGet, s.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ForwardTo(req, func(h http.Header, r io.Reader) error {
+		return ResumeFile(r, filepath.Join(t.TempDir(), "file"), 0)
+	})
+
+	if !IsServerError(err) {
+		t.Fatalf("the broken body is not a server error, err:%v", err)
+	}
+}
@@ -0,0 +1,322 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type ServerTLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+
+	// ClientCAFile is used to verify the client certificates.
+	ClientCAFile string `json:"client_ca_file"`
+
+	// VerifyClient rejects the clients without a valid certificate.
+	VerifyClient bool `json:"verify_client"`
+}
+
+func (c *ServerTLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+func (c *ServerTLSConfig) Validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("cert file and key file must be set at same time")
+	}
+
+	if c.ClientCAFile != "" && !c.Enabled() {
+		return fmt.Errorf("client ca file is set, but tls is disabled")
+	}
+
+	if c.VerifyClient && c.ClientCAFile == "" {
+		return fmt.Errorf("missing client ca file to verify client")
+	}
+
+	return nil
+}
+
+type ClientTLSConfig struct {
+	// CAFile is the CA bundle to verify the servers.
+	CAFile string `json:"ca_file"`
+
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+func (c *ClientTLSConfig) Enabled() bool {
+	return c.CAFile != "" || c.CertFile != ""
+}
+
+func (c *ClientTLSConfig) Validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("cert file and key file must be set at same time")
+	}
+
+	return nil
+}
+
+// NewServerTLSConfig returns the tls config of the http server.
+// The certificates are reloaded when the files change.
+func NewServerTLSConfig(cfg *ServerTLSConfig) (*tls.Config, error) {
+	cert := &certReloader{certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+	if _, err := cert.get(); err != nil {
+		return nil, err
+	}
+
+	v := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return cert.get()
+		},
+	}
+
+	if cfg.ClientCAFile == "" {
+		return v, nil
+	}
+
+	ca := &caReloader{file: cfg.ClientCAFile}
+	if _, err := ca.get(); err != nil {
+		return nil, err
+	}
+
+	clientAuth := tls.VerifyClientCertIfGiven
+	if cfg.VerifyClient {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	v.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		pool, err := ca.get()
+		if err != nil {
+			return nil, err
+		}
+
+		c := v.Clone()
+		c.GetConfigForClient = nil
+		c.ClientCAs = pool
+		c.ClientAuth = clientAuth
+
+		return c, nil
+	}
+
+	return v, nil
+}
+
+// ClientTLS is the tls config used to access the servers.
+type ClientTLS struct {
+	base *tls.Config
+
+	// ca is nil if the servers are verified with the system roots.
+	ca *caReloader
+}
+
+// NewClientTLSConfig returns the tls config used to access the servers.
+// The certificates are reloaded when the files change.
+func NewClientTLSConfig(cfg *ClientTLSConfig) (*ClientTLS, error) {
+	v := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.CertFile != "" {
+		cert := &certReloader{certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+		if _, err := cert.get(); err != nil {
+			return nil, err
+		}
+
+		v.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return cert.get()
+		}
+	}
+
+	r := &ClientTLS{base: v}
+
+	if cfg.CAFile == "" {
+		return r, nil
+	}
+
+	r.ca = &caReloader{file: cfg.CAFile}
+	if _, err := r.ca.get(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Config returns the tls config of the connection to host which is the
+// name or IP of server dialed. The server certificate is verified with
+// it. If host is empty, the ServerName of connection is used instead,
+// which is empty if the server is an IP, then the connection fails.
+func (c *ClientTLS) Config(host string) *tls.Config {
+	v := c.base.Clone()
+
+	if host != "" {
+		v.ServerName = host
+	}
+
+	if c.ca == nil {
+		return v
+	}
+
+	// RootCAs can't be changed once the config is in use, so the server
+	// certificate is verified here with the current CA bundle instead.
+	v.InsecureSkipVerify = true
+	v.VerifyConnection = func(cs tls.ConnectionState) error {
+		name := host
+		if name == "" {
+			name = cs.ServerName
+		}
+
+		return c.verify(cs, name)
+	}
+
+	return v
+}
+
+func (c *ClientTLS) verify(cs tls.ConnectionState, name string) error {
+	if name == "" {
+		return fmt.Errorf("no server name to verify")
+	}
+
+	pool, err := c.ca.get()
+	if err != nil {
+		return err
+	}
+
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("no server certificate")
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       name,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, err = cs.PeerCertificates[0].Verify(opts)
+
+	return err
+}
+
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func getFileVersion(f string) (fileVersion, error) {
+	v, err := os.Stat(f)
+	if err != nil {
+		return fileVersion{}, err
+	}
+
+	return fileVersion{v.ModTime(), v.Size()}, nil
+}
+
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	lock    sync.Mutex
+	cert    *tls.Certificate
+	version [2]fileVersion
+}
+
+func (r *certReloader) get() (*tls.Certificate, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	v0, err := getFileVersion(r.certFile)
+	if err != nil {
+		return r.fallback(err)
+	}
+
+	v1, err := getFileVersion(r.keyFile)
+	if err != nil {
+		return r.fallback(err)
+	}
+
+	if r.cert != nil && r.version == [2]fileVersion{v0, v1} {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return r.fallback(err)
+	}
+
+	if r.cert != nil {
+		LogInfo("reload certificate:%s", r.certFile)
+	}
+
+	r.cert = &cert
+	r.version = [2]fileVersion{v0, v1}
+
+	return r.cert, nil
+}
+
+// fallback keeps using the loaded certificate when the files are
+// being replaced.
+func (r *certReloader) fallback(err error) (*tls.Certificate, error) {
+	if r.cert != nil {
+		LogErr("reload certificate:%s, err:%s", r.certFile, err.Error())
+
+		return r.cert, nil
+	}
+
+	return nil, err
+}
+
+type caReloader struct {
+	file string
+
+	lock    sync.Mutex
+	pool    *x509.CertPool
+	version fileVersion
+}
+
+func (r *caReloader) get() (*x509.CertPool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	v, err := getFileVersion(r.file)
+	if err != nil {
+		return r.fallback(err)
+	}
+
+	if r.pool != nil && r.version == v {
+		return r.pool, nil
+	}
+
+	b, err := os.ReadFile(r.file)
+	if err != nil {
+		return r.fallback(err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return r.fallback(fmt.Errorf("no certificate in %s", r.file))
+	}
+
+	if r.pool != nil {
+		LogInfo("reload ca bundle:%s", r.file)
+	}
+
+	r.pool = pool
+	r.version = v
+
+	return r.pool, nil
+}
+
+func (r *caReloader) fallback(err error) (*x509.CertPool, error) {
+	if r.pool != nil {
+		LogErr("reload ca bundle:%s, err:%s", r.file, err.Error())
+
+		return r.pool, nil
+	}
+
+	return nil, err
+}
@@ -0,0 +1,226 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert returns a certificate of ips signed by ca, or a CA if ca is nil.
+func newTestCert(t *testing.T, ca *testCert, ips ...string) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "obs-worker test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	for _, ip := range ips {
+		tmpl.IPAddresses = append(tmpl.IPAddresses, net.ParseIP(ip))
+	}
+
+	parent, signer := tmpl, key
+	if ca == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// writeTestCert writes the files and changes the modification time, so
+// that the reloaders find the change even if the size is the same.
+func writeTestCert(t *testing.T, c *testCert, certFile, keyFile string, modTime time.Time) {
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+	if err := os.WriteFile(certFile, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(certFile, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	if keyFile == "" {
+		return
+	}
+
+	k, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: k})
+	if err := os.WriteFile(keyFile, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(keyFile, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testTLSEnv struct {
+	dir    string
+	url    string
+	client *ClientTLS
+}
+
+func (env *testTLSEnv) file(name string) string {
+	return filepath.Join(env.dir, name)
+}
+
+func newTestTLSEnv(t *testing.T, serverCert, ca *testCert) *testTLSEnv {
+	env := &testTLSEnv{dir: t.TempDir()}
+
+	now := time.Now()
+	writeTestCert(t, serverCert, env.file("server.crt"), env.file("server.key"), now)
+	writeTestCert(t, ca, env.file("ca.crt"), "", now)
+
+	sc, err := NewServerTLSConfig(&ServerTLSConfig{
+		CertFile: env.file("server.crt"),
+		KeyFile:  env.file("server.key"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// httptest.Server is not used, because its own certificate is
+	// preferred to GetCertificate when the client doesn't send SNI.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &http.Server{
+		Handler:   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		TLSConfig: sc,
+		ErrorLog:  log.New(ioutil.Discard, "", 0),
+	}
+	go s.ServeTLS(ln, "", "")
+	t.Cleanup(func() { s.Close() })
+
+	env.url = "https://" + ln.Addr().String()
+
+	env.client, err = NewClientTLSConfig(&ClientTLSConfig{CAFile: env.file("ca.crt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return env
+}
+
+// get requests with a new client, so that every request makes a new connection.
+func (env *testTLSEnv) get() error {
+	c := newHTTPClient(&HTTPOptions{ConnectTimeout: 5}, env.client)
+	defer c.CloseIdleConnections()
+
+	resp, err := c.Get(env.url)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+func TestClientVerifiesDialedIP(t *testing.T) {
+	ca := newTestCert(t, nil)
+
+	// the server is accessed by 127.0.0.1, and the certificate is not of it.
+	env := newTestTLSEnv(t, newTestCert(t, ca, "10.0.0.1"), ca)
+
+	if err := env.get(); err == nil {
+		t.Fatal("accept the certificate of other ip")
+	}
+
+	writeTestCert(
+		t, newTestCert(t, ca, "127.0.0.1"),
+		env.file("server.crt"), env.file("server.key"), time.Now().Add(time.Minute),
+	)
+
+	if err := env.get(); err != nil {
+		t.Fatalf("reject the reloaded certificate of server, err:%v", err)
+	}
+}
+
+func TestClientReloadsCA(t *testing.T) {
+	ca, other := newTestCert(t, nil), newTestCert(t, nil)
+
+	env := newTestTLSEnv(t, newTestCert(t, other, "127.0.0.1"), ca)
+
+	if err := env.get(); err == nil {
+		t.Fatal("accept the certificate signed by an unknown ca")
+	}
+
+	writeTestCert(t, other, env.file("ca.crt"), "", time.Now().Add(time.Minute))
+
+	if err := env.get(); err != nil {
+		t.Fatalf("reject the certificate signed by the reloaded ca, err:%v", err)
+	}
+
+	// the loaded ca is kept if the new file is broken.
+	if err := os.WriteFile(env.file("ca.crt"), []byte("broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := env.get(); err != nil {
+		t.Fatalf("the loaded ca is dropped, err:%v", err)
+	}
+}
+
+func TestClientWithoutServerName(t *testing.T) {
+	ca := newTestCert(t, nil)
+
+	env := newTestTLSEnv(t, newTestCert(t, ca, "127.0.0.1"), ca)
+
+	// the requests through a proxy verify the server with the ServerName,
+	// which is empty for an ip, so they must fail.
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: env.client.Config("")}}
+	defer c.CloseIdleConnections()
+
+	if _, err := c.Get(env.url); err == nil {
+		t.Fatal("accept the server without a name to verify")
+	}
+}
@@ -40,11 +40,17 @@ func (h *transferData) do(r TransferRead, w TransferWrite) error {
 
 	wg.Wait()
 
-	if rerr == nil && werr == nil {
-		return nil
+	// keep the error, so the caller can tell whether it is
+	// caused by the network or the local file.
+	if werr == nil {
+		return rerr
 	}
 
-	return fmt.Errorf("%v, %v", rerr, werr)
+	if rerr == nil {
+		return werr
+	}
+
+	return fmt.Errorf("%w, %v", rerr, werr)
 }
 
 func (h *transferData) read(r TransferRead) error {
@@ -6,30 +6,89 @@ import (
 	"os"
 )
 
+// DownloadFile removes the partial file if the download fails,
+// such as the request is canceled.
 func DownloadFile(r io.Reader, file string) error {
+	return download(file, func(w io.Writer) error {
+		return transfer(r, w)
+	})
+}
+
+func DownloadFileWithSize(r io.Reader, size int64, file string) error {
+	return download(file, func(w io.Writer) error {
+		return limitedTransfer(r, size, w)
+	})
+}
+
+// DownloadFileWithSizeTee writes the data to w too if w is not nil.
+func DownloadFileWithSizeTee(r io.Reader, size int64, file string, w io.Writer) error {
+	if w == nil {
+		return DownloadFileWithSize(r, size, file)
+	}
+
+	return download(file, func(fo io.Writer) error {
+		return limitedTransfer(r, size, io.MultiWriter(fo, w))
+	})
+}
+
+// TeeRead reads the data and writes it to w, or drops it if w is nil.
+func TeeRead(r io.Reader, size int64, w io.Writer) error {
+	if w == nil {
+		return EmptyRead(r, size)
+	}
+
+	return limitedTransfer(r, size, w)
+}
+
+func download(file string, do func(io.Writer) error) error {
 	fo, err := os.Create(file)
 	if err != nil {
 		return err
 	}
 
-	defer fo.Close()
+	err = do(fo)
 
-	return transfer(r, fo)
+	if err1 := fo.Close(); err == nil {
+		err = err1
+	}
+
+	if err != nil {
+		os.Remove(file)
+	}
+
+	return err
 }
 
-func DownloadFileWithSize(r io.Reader, size int64, file string) error {
-	fo, err := os.Create(file)
+// ResumeFile writes the data to file from offset. The data before offset
+// is kept and the partial file is not removed, so it can be resumed again.
+func ResumeFile(r io.Reader, file string, offset int64) error {
+	fo, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
 		return err
 	}
 
 	defer fo.Close()
 
-	return limitedTransfer(r, size, fo)
+	if err := fo.Truncate(offset); err != nil {
+		return err
+	}
+
+	if _, err := fo.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	return transfer(r, fo)
 }
 
 func EmptyRead(r io.Reader, size int64) error {
-	return DownloadFileWithSize(r, size, os.DevNull)
+	fo, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	defer fo.Close()
+
+	return limitedTransfer(r, size, fo)
 }
 
 func limitedTransfer(r io.Reader, size int64, w io.Writer) error {
@@ -1,34 +1,57 @@
 package worker
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
 
 	"github.com/zengchen1024/obs-worker/build"
 	"github.com/zengchen1024/obs-worker/sdk/buildinfo"
 	"github.com/zengchen1024/obs-worker/sdk/worker"
 	"github.com/zengchen1024/obs-worker/sdk/workerstate"
+	"github.com/zengchen1024/obs-worker/utils"
 )
 
-var instance *BuildManager
+const (
+	ShutdownPolicyWait = "wait"
+	ShutdownPolicyKill = "kill"
+)
 
-func GetBuildManager() *BuildManager {
-	return instance
-}
+var (
+	ErrNotBuilding = fmt.Errorf("not building a job")
+	ErrOtherJob    = fmt.Errorf("building a different job")
+)
 
 type BuildManager struct {
-	cfg     *build.Config
-	w       worker.Worker
-	port    int
-	workDir string
+	cfg  *build.Config
+	w    worker.Worker
+	port int
 
 	lock  sync.RWMutex
 	state workerstate.WorkerState
 	opLog string
 
+	registered sets.String
+
 	job       build.Build
+	jobDone   chan struct{}
 	nobadhost string
+	exiting   bool
+	draining  bool
+
+	onDrained func()
+
+	// upload is the spooled result of last job which is being uploaded.
+	// uploadStop is closed when the worker exits, then the upload is
+	// tried once more without waiting, until uploadCtx is canceled.
+	upload       *build.ResultUpload
+	uploadStop   chan struct{}
+	uploadCtx    context.Context
+	uploadCancel context.CancelFunc
 
 	wg sync.WaitGroup
 }
@@ -37,6 +60,21 @@ func (b *BuildManager) GetJob(jobid string) (buildinfo.BuildInfo, error) {
 	b.lock.RLock()
 	defer b.lock.RUnlock()
 
+	return b.getJob(jobid)
+}
+
+// GetJobSnapshot returns the job and the state of worker read at once,
+// so they are consistent with each other.
+func (b *BuildManager) GetJobSnapshot(jobid string) (buildinfo.BuildInfo, workerstate.WorkerState, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	info, err := b.getJob(jobid)
+
+	return info, b.state, err
+}
+
+func (b *BuildManager) getJob(jobid string) (buildinfo.BuildInfo, error) {
 	info := buildinfo.BuildInfo{}
 	state := b.state.State
 
@@ -53,10 +91,42 @@ func (b *BuildManager) GetJob(jobid string) (buildinfo.BuildInfo, error) {
 	return info, nil
 }
 
+func (b *BuildManager) GetProgress(jobid string) (build.Progress, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	if err := b.checkWorkerState(jobid, true); err != nil {
+		return build.Progress{}, err
+	}
+
+	return b.job.GetProgress(), nil
+}
+
+func (b *BuildManager) GetWorkerState() workerstate.WorkerState {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return b.state
+}
+
 func (b *BuildManager) GetWorkerInfo(jobid string) (worker.Worker, error) {
 	b.lock.RLock()
 	defer b.lock.RUnlock()
 
+	return b.getWorker(jobid)
+}
+
+// GetWorkerSnapshot returns the worker and its state read at once.
+func (b *BuildManager) GetWorkerSnapshot(jobid string) (worker.Worker, workerstate.WorkerState, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	w, err := b.getWorker(jobid)
+
+	return w, b.state, err
+}
+
+func (b *BuildManager) getWorker(jobid string) (worker.Worker, error) {
 	w := worker.Worker{}
 
 	if err := b.checkWorkerState(jobid, false); err != nil {
@@ -96,15 +166,24 @@ func (b *BuildManager) SetBadHostJob(jobid string) error {
 	return b.updateJob(jobid, workerstate.WorkerStateBadHost, "\n\nTriggered badhost state for job\n")
 }
 
-func (b *BuildManager) GetBuildLog(jobid string, callback func(string) error) error {
+// GetBuildLog calls the callback with the log file and a channel which
+// is closed when the job ends. The lock is not held by the callback,
+// so it can follow the log as long as the job is running.
+func (b *BuildManager) GetBuildLog(jobid string, callback func(string, <-chan struct{}) error) error {
 	b.lock.RLock()
-	defer b.lock.RUnlock()
 
 	if err := b.checkWorkerState(jobid, true); err != nil {
+		b.lock.RUnlock()
+
 		return err
 	}
 
-	return callback(b.job.GetBuildLogFile())
+	file := b.job.GetBuildLogFile()
+	done := b.jobDone
+
+	b.lock.RUnlock()
+
+	return callback(file, done)
 }
 
 func (b *BuildManager) updateJob(jobid, state, log string) error {
@@ -124,16 +203,33 @@ func (b *BuildManager) updateJob(jobid, state, log string) error {
 
 	b.state.State = state
 
+	b.closeJobDone()
+
 	return nil
 }
 
+func (b *BuildManager) closeJobDone() {
+	select {
+	case <-b.jobDone:
+	default:
+		close(b.jobDone)
+	}
+}
+
+func (b *BuildManager) isBuildingJob(jobid string) bool {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return b.state.State == workerstate.WorkerStateBuilding && b.state.JobId == jobid
+}
+
 func (b *BuildManager) checkWorkerState(jobid string, needBuilding bool) error {
 	if (jobid != "" || needBuilding) && b.state.State != workerstate.WorkerStateBuilding {
-		return fmt.Errorf("not building a job")
+		return ErrNotBuilding
 	}
 
 	if jobid != "" && jobid != b.state.JobId {
-		return fmt.Errorf("building a different job")
+		return ErrOtherJob
 	}
 
 	return nil
@@ -143,35 +239,96 @@ func (b *BuildManager) wait() {
 	b.wg.Wait()
 }
 
-func Init(cfg *build.Config, port int) error {
-	b := BuildManager{
-		cfg:  cfg,
-		port: port,
+func (b *BuildManager) waitTimeout(timeout time.Duration) bool {
+	done := make(chan struct{})
+
+	go func() {
+		b.wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
 	}
+}
 
-	if err := b.getWorkerInfo(); err != nil {
-		return err
+func (b *BuildManager) exit(policy string, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+
+	b.lock.Lock()
+	if !b.exiting {
+		b.exiting = true
+		close(b.uploadStop)
 	}
+	jobId := ""
+	if b.state.State == workerstate.WorkerStateBuilding {
+		jobId = b.state.JobId
+	}
+	b.lock.Unlock()
 
-	dir, err := os.Getwd()
-	if err != nil {
-		return err
+	if jobId != "" {
+		if policy == ShutdownPolicyWait {
+			utils.LogInfo("wait at most %s for job:%s to finish", timeout, jobId)
+
+			if b.waitTimeout(timeout) {
+				jobId = ""
+			}
+		}
+
+		if jobId != "" {
+			utils.LogInfo("kill job:%s before exiting", jobId)
+
+			if err := b.KillJob(jobId); err != nil {
+				utils.LogErr("kill job:%s, err:%s", jobId, err.Error())
+			}
+		}
 	}
 
-	b.workDir = dir
+	// the spooled result is tried once before the deadline, and it is
+	// uploaded after restarting if it still fails.
+	if !b.waitTimeout(time.Until(deadline)) {
+		b.uploadCancel()
 
-	b.sendIdleState()
+		b.wait()
+	}
 
-	b.state.State = workerstate.WorkerStateIdle
+	b.sendExitState()
+}
 
-	instance = &b
+func ValidateShutdownPolicy(policy string) error {
+	if policy != ShutdownPolicyWait && policy != ShutdownPolicyKill {
+		return fmt.Errorf("unknown shutdown policy:%s", policy)
+	}
 
 	return nil
 }
 
-func Exit() {
-	if instance != nil {
-		instance.sendExitState()
-		instance.wait()
+func newBuildManager(cfg *build.Config, port int) (*BuildManager, error) {
+	b := &BuildManager{
+		cfg:  cfg,
+		port: port,
+	}
+
+	if err := b.getWorkerInfo(); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(cfg.StateDir, 0755); err != nil {
+		return nil, err
+	}
+
+	b.uploadStop = make(chan struct{})
+	b.uploadCtx, b.uploadCancel = context.WithCancel(context.Background())
+
+	// don't accept job until the result of last job is uploaded.
+	if b.upload = b.loadResultUpload(); b.upload == nil {
+		b.sendIdleState()
 	}
+
+	b.state.State = workerstate.WorkerStateIdle
+
+	return b, nil
 }
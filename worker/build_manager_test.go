@@ -0,0 +1,369 @@
+package worker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zengchen1024/obs-worker/build"
+	"github.com/zengchen1024/obs-worker/sdk/buildinfo"
+	"github.com/zengchen1024/obs-worker/sdk/fakeobs"
+	"github.com/zengchen1024/obs-worker/sdk/workerstate"
+)
+
+// fakeBuildScript builds a package after sleeping the seconds in the file
+// sleep of state dir if it exists. The sleep is stopped by --kill.
+const fakeBuildScript = `#!/bin/sh
+state=$BUILD_DIR/..
+for arg in "$@"; do
+	case "$prev" in
+	--root) root=$arg ;;
+	--logfile) logfile=$arg ;;
+	esac
+	prev=$arg
+done
+
+if [ "$arg" = "--kill" ]; then
+	kill $(cat "$state/pid")
+	exit 0
+fi
+
+echo "building" >> "$logfile"
+
+if [ -f "$state/sleep" ]; then
+	sleep $(cat "$state/sleep") &
+	echo $! > "$state/pid"
+	wait $! || exit 1
+fi
+
+mkdir -p "$root/.build.packages/RPMS/x86_64"
+echo "pkg" > "$root/.build.packages/RPMS/x86_64/pkg-1.0-1.x86_64.rpm"
+`
+
+type testSlot struct {
+	*BuildManager
+
+	server *fakeobs.Server
+	info   buildinfo.BuildInfo
+}
+
+func newTestSlot(t *testing.T, policy build.ResultUploadPolicy) *testSlot {
+	dir := t.TempDir()
+
+	verifyMd5, err := fakeobs.WritePackage(dir, &fakeobs.Package{
+		Project:    "prj",
+		Repository: "repo",
+		Arch:       "x86_64",
+		Name:       "pkg",
+		Sources:    map[string]string{"pkg.spec": "Name: pkg\n"},
+		Config:     "Type: spec\n",
+		Binaries:   map[string][]byte{"gcc.rpm": fakeobs.RPM("gcc")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := fakeobs.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	stateDir := t.TempDir()
+	script := filepath.Join(stateDir, "build", "build")
+	if err := os.MkdirAll(filepath.Dir(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(script, []byte(fakeBuildScript), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &build.Config{
+		Id:           "worker:1",
+		HostArch:     "x86_64",
+		StateDir:     stateDir,
+		BuildRoot:    filepath.Join(t.TempDir(), "root"),
+		RepoServers:  []string{s.URL},
+		ResultUpload: policy,
+	}
+	if err := cfg.SetDefault(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := newBuildManager(cfg, 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := buildinfo.BuildInfo{
+		Project:     "prj",
+		Repository:  "repo",
+		Package:     "pkg",
+		SrcServer:   s.URL,
+		RepoServer:  s.URL,
+		Job:         "prj::repo::pkg-srcmd5",
+		Arch:        "x86_64",
+		SrcMd5:      "srcmd5",
+		VerifyMd5:   verifyMd5,
+		File:        "pkg.spec",
+		NoUnchanged: "1",
+		BDeps: []buildinfo.BDep{
+			{Name: "gcc", Project: "prj", Repository: "repo", RepoArch: "x86_64"},
+		},
+		Paths: []buildinfo.Path{{Project: "prj", Repository: "repo", Server: s.URL}},
+	}
+
+	return &testSlot{BuildManager: b, server: s, info: info}
+}
+
+// build starts the job which sleeps seconds before the package is built.
+func (s *testSlot) build(t *testing.T, seconds int) {
+	f := filepath.Join(s.cfg.StateDir, "sleep")
+	if seconds == 0 {
+		os.Remove(f)
+	} else if err := os.WriteFile(f, []byte(strconv.Itoa(seconds)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.createJob("", &Job{Id: "1", BuildInfo: s.info}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// exitState returns the last state sent to the repo server.
+func (s *testSlot) exitState() string {
+	v := s.server.Registrations()
+	if len(v) == 0 {
+		return ""
+	}
+
+	return v[len(v)-1].State
+}
+
+func waitFor(t *testing.T, what string, timeout time.Duration, cond func() bool) {
+	for deadline := time.Now().Add(timeout); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout to wait for %s", what)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExitWaitsForJob(t *testing.T) {
+	s := newTestSlot(t, build.ResultUploadPolicy{})
+
+	s.build(t, 1)
+
+	s.exit(ShutdownPolicyWait, 30*time.Second)
+
+	if v := s.server.Uploads(); len(v) != 1 || v[0].Code != "succeeded" {
+		t.Fatalf("got uploads:%+v", v)
+	}
+
+	if v := s.exitState(); v != workerstate.WorkerStateExit {
+		t.Fatalf("got state:%s", v)
+	}
+}
+
+func TestExitKillsJob(t *testing.T) {
+	for _, policy := range []string{ShutdownPolicyWait, ShutdownPolicyKill} {
+		s := newTestSlot(t, build.ResultUploadPolicy{})
+
+		s.build(t, 30)
+
+		waitFor(t, "starting the build", 10*time.Second, func() bool {
+			_, err := os.Stat(filepath.Join(s.cfg.StateDir, "pid"))
+
+			return err == nil
+		})
+
+		start := time.Now()
+		s.exit(policy, time.Second)
+
+		if d := time.Since(start); d > 10*time.Second {
+			t.Fatalf("%s: exit takes %s", policy, d)
+		}
+
+		// the killed job is reported as failed.
+		if v := s.server.Uploads(); len(v) != 1 || v[0].Code != "failed" {
+			t.Fatalf("%s: got uploads:%+v", policy, v)
+		}
+
+		if v := s.exitState(); v != workerstate.WorkerStateExit {
+			t.Fatalf("%s: got state:%s", policy, v)
+		}
+	}
+}
+
+func TestExitTriesSpooledUpload(t *testing.T) {
+	// it will not retry before the worker exits.
+	s := newTestSlot(t, build.ResultUploadPolicy{Backoff: 600, MaxBackoff: 600})
+
+	s.server.SetStatus("/putjob", 500)
+
+	s.build(t, 0)
+
+	waitFor(t, "spooling the result", 10*time.Second, func() bool {
+		return s.idle() && s.uploading()
+	})
+
+	s.server.SetStatus("/putjob", 0)
+
+	start := time.Now()
+	s.exit(ShutdownPolicyWait, 10*time.Second)
+
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("exit takes %s", d)
+	}
+
+	if v := s.server.Uploads(); len(v) != 1 || v[0].Code != "succeeded" {
+		t.Fatalf("got uploads:%+v", v)
+	}
+
+	if s.spooled(t) != nil {
+		t.Fatal("the uploaded result is kept")
+	}
+}
+
+func TestExitKeepsFailedUpload(t *testing.T) {
+	s := newTestSlot(t, build.ResultUploadPolicy{Backoff: 600, MaxBackoff: 600})
+
+	s.server.SetStatus("/putjob", 500)
+
+	s.build(t, 0)
+
+	waitFor(t, "spooling the result", 10*time.Second, func() bool {
+		return s.idle() && s.uploading()
+	})
+
+	n := s.putJobs()
+
+	s.exit(ShutdownPolicyWait, 10*time.Second)
+
+	if v := s.putJobs(); v != n+1 {
+		t.Fatalf("upload %d times when exiting", v-n)
+	}
+
+	// it is resumed after restarting.
+	u := s.spooled(t)
+	if u == nil || u.Attempts != 2 {
+		t.Fatalf("got spooled result:%+v", u)
+	}
+}
+
+func TestKillJobCancelsDownload(t *testing.T) {
+	s := newTestSlot(t, build.ResultUploadPolicy{})
+
+	backend, err := url.Parse(s.server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(backend)
+	blocked := make(chan struct{}, 1)
+	cancelled := make(chan struct{}, 1)
+
+	// the binaries are never sent until the request is cancelled.
+	p := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/getbinaries" {
+			proxy.ServeHTTP(w, r)
+
+			return
+		}
+
+		blocked <- struct{}{}
+
+		<-r.Context().Done()
+
+		cancelled <- struct{}{}
+	}))
+	defer p.Close()
+
+	s.info.Paths[0].Server = p.URL
+
+	s.build(t, 0)
+
+	select {
+	case <-blocked:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout to wait for downloading the binaries")
+	}
+
+	if err := s.KillJob("1"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the download is not cancelled")
+	}
+
+	waitFor(t, "the end of job", 10*time.Second, s.idle)
+
+	// it is not retried as a failed download.
+	select {
+	case <-blocked:
+		t.Fatal("the download is retried after the job is killed")
+	default:
+	}
+
+	v := s.server.Uploads()
+	if len(v) != 1 {
+		t.Fatalf("got uploads:%+v", v)
+	}
+
+	var log []byte
+	for _, f := range v[0].Files {
+		if f.Name == "logfile" {
+			if log, err = os.ReadFile(f.Path); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if !strings.Contains(string(log), "Killed job") || !strings.Contains(string(log), context.Canceled.Error()) {
+		t.Fatalf("the job is not ended by the kill, log:%s", log)
+	}
+}
+
+func TestGetJobSnapshot(t *testing.T) {
+	s := newTestSlot(t, build.ResultUploadPolicy{})
+
+	s.build(t, 30)
+
+	info, state, err := s.GetJobSnapshot("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Job != s.info.Job || state.State != workerstate.WorkerStateBuilding || state.JobId != "1" {
+		t.Fatalf("got job:%s, state:%+v", info.Job, state)
+	}
+
+	if err := s.KillJob("1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the job is not reported as building once it is killed.
+	info, state, err = s.GetJobSnapshot("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Job != "" || info.Error != state.State || state.State == workerstate.WorkerStateBuilding {
+		t.Fatalf("got job:%+v, state:%+v", info, state)
+	}
+
+	s.wait()
+}
@@ -0,0 +1,19 @@
+package worker
+
+import (
+	"fmt"
+
+	"github.com/zengchen1024/obs-worker/build"
+)
+
+var ErrNoCache = fmt.Errorf("cache is not enabled")
+
+// CheckCache checks the cache shared by all the slots and repairs it.
+func CheckCache(verifyRPM bool) (build.CacheCheckReport, error) {
+	cfg := instance.slots[0].cfg
+	if cfg.CacheDir == "" {
+		return build.CacheCheckReport{}, ErrNoCache
+	}
+
+	return build.CheckCache(cfg, verifyRPM)
+}
@@ -0,0 +1,139 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/zengchen1024/obs-worker/sdk/workerstate"
+	"github.com/zengchen1024/obs-worker/utils"
+)
+
+var ErrDraining = fmt.Errorf("worker is draining, it doesn't accept new job")
+
+type drainer struct {
+	lock sync.Mutex
+
+	exitAfterDrain bool
+}
+
+// Drain stops all the slots accepting new jobs. The idle slots leave the
+// repo servers at once, and the building ones leave after the job ends.
+// If exit is true, the process exits after all the slots are drained.
+func Drain(exit bool) {
+	instance.drain(exit)
+}
+
+// Undrain restores the normal operation.
+func Undrain() {
+	instance.undrain()
+}
+
+func IsDraining() bool {
+	return instance.slots[0].isDraining()
+}
+
+func (m *slotManager) drain(exit bool) {
+	m.drainer.lock.Lock()
+	m.drainer.exitAfterDrain = exit
+	m.drainer.lock.Unlock()
+
+	utils.LogInfo("start draining, exit after drained: %v", exit)
+
+	for _, s := range m.slots {
+		s.drain()
+	}
+
+	m.checkDrained()
+}
+
+func (m *slotManager) undrain() {
+	m.drainer.lock.Lock()
+	m.drainer.exitAfterDrain = false
+	m.drainer.lock.Unlock()
+
+	utils.LogInfo("stop draining")
+
+	for _, s := range m.slots {
+		s.undrain()
+	}
+}
+
+// checkDrained is called when a slot has been drained.
+func (m *slotManager) checkDrained() {
+	for _, s := range m.slots {
+		if !s.isDrained() {
+			return
+		}
+	}
+
+	m.drainer.lock.Lock()
+	exit := m.drainer.exitAfterDrain
+	m.drainer.exitAfterDrain = false
+	m.drainer.lock.Unlock()
+
+	if !exit {
+		return
+	}
+
+	utils.LogInfo("all slots are drained, exit")
+
+	// the same as being stopped, so the server shuts down gracefully.
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		utils.LogErr("send SIGTERM to self, err:%s", err.Error())
+	}
+}
+
+func (b *BuildManager) drain() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.draining {
+		return
+	}
+
+	b.draining = true
+
+	if b.state.State == workerstate.WorkerStateIdle {
+		b.leave()
+	}
+}
+
+func (b *BuildManager) undrain() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if !b.draining {
+		return
+	}
+
+	b.draining = false
+
+	if b.state.State == workerstate.WorkerStateIdle && !b.exiting && b.upload == nil {
+		b.sendIdleState()
+	}
+}
+
+// leave tells the repo servers this slot is not available.
+func (b *BuildManager) leave() {
+	b.sendExitState()
+
+	b.registered = sets.NewString()
+}
+
+func (b *BuildManager) isDraining() bool {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return b.draining
+}
+
+func (b *BuildManager) isDrained() bool {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return b.draining && b.state.State == workerstate.WorkerStateIdle && b.upload == nil
+}
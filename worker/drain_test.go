@@ -0,0 +1,62 @@
+package worker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zengchen1024/obs-worker/build"
+	"github.com/zengchen1024/obs-worker/sdk/workerstate"
+)
+
+func TestDrain(t *testing.T) {
+	s := newTestSlot(t, build.ResultUploadPolicy{})
+
+	drained := make(chan struct{}, 1)
+	s.onDrained = func() {
+		drained <- struct{}{}
+	}
+
+	s.build(t, 1)
+
+	s.drain()
+
+	if err := s.createJob("", &Job{Id: "2", BuildInfo: s.info}); !errors.Is(err, ErrDraining) {
+		t.Fatalf("expect ErrDraining, got:%v", err)
+	}
+
+	// the running job is done before the slot is drained.
+	select {
+	case <-drained:
+	case <-time.After(30 * time.Second):
+		t.Fatal("timeout to wait for draining")
+	}
+
+	if v := s.server.Uploads(); len(v) != 1 || v[0].Code != "succeeded" {
+		t.Fatalf("got uploads:%+v", v)
+	}
+
+	if !s.isDrained() {
+		t.Fatal("the slot is not drained")
+	}
+
+	if v := s.exitState(); v != workerstate.WorkerStateExit {
+		t.Fatalf("got state after drained:%s", v)
+	}
+
+	s.undrain()
+
+	if v := s.exitState(); v != workerstate.WorkerStateIdle {
+		t.Fatalf("got state after undrained:%s", v)
+	}
+
+	s.build(t, 0)
+
+	waitFor(t, "the job after undrained", 30*time.Second, func() bool {
+		return len(s.server.Uploads()) == 2
+	})
+
+	if v := s.server.Uploads(); v[1].Code != "succeeded" {
+		t.Fatalf("got uploads:%+v", v)
+	}
+}
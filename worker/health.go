@@ -0,0 +1,142 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/zengchen1024/obs-worker/sdk/workerstate"
+)
+
+type DiskHealth struct {
+	Path   string `json:"path"`
+	FreeMB int64  `json:"free_mb"`
+	OK     bool   `json:"ok"`
+}
+
+type Health struct {
+	WorkerId          string       `json:"worker_id"`
+	State             string       `json:"state"`
+	JobId             string       `json:"job_id,omitempty"`
+	Ready             bool         `json:"ready"`
+	Draining          bool         `json:"draining"`
+	UploadingJobId    string       `json:"uploading_job_id,omitempty"`
+	RegisteredServers []string     `json:"registered_servers"`
+	BuildScript       bool         `json:"build_script"`
+	Disks             []DiskHealth `json:"disks"`
+	Problems          []string     `json:"problems,omitempty"`
+}
+
+// CheckHealth returns the readiness of every slot.
+func CheckHealth() []Health {
+	if instance == nil {
+		return nil
+	}
+
+	r := make([]Health, len(instance.slots))
+	for i, s := range instance.slots {
+		r[i] = s.checkHealth()
+	}
+
+	return r
+}
+
+func (b *BuildManager) checkHealth() Health {
+	b.lock.RLock()
+
+	h := Health{
+		WorkerId:          b.cfg.Id,
+		State:             b.state.State,
+		RegisteredServers: b.registered.List(),
+		Draining:          b.draining,
+	}
+
+	if h.State != workerstate.WorkerStateIdle {
+		h.JobId = b.state.JobId
+	}
+
+	if b.upload != nil {
+		h.UploadingJobId = b.upload.JobId()
+	}
+
+	exiting := b.exiting
+
+	b.lock.RUnlock()
+
+	problem := func(f string, v ...interface{}) {
+		h.Problems = append(h.Problems, fmt.Sprintf(f, v...))
+	}
+
+	if exiting {
+		problem("worker is exiting")
+	}
+
+	if h.Draining {
+		problem("worker is draining")
+	}
+
+	if h.UploadingJobId != "" {
+		problem("uploading the result of job:%s", h.UploadingJobId)
+	}
+
+	if len(h.RegisteredServers) == 0 {
+		problem("not registered to any repo server")
+	}
+
+	f := filepath.Join(b.cfg.StateDir, "build", "build")
+	if v, err := os.Stat(f); err == nil && !v.IsDir() {
+		h.BuildScript = true
+	} else {
+		problem("build script %s is not present", f)
+	}
+
+	dirs := []string{b.cfg.BuildRoot}
+	if b.cfg.CacheDir != "" {
+		dirs = append(dirs, b.cfg.CacheDir)
+	}
+
+	for _, dir := range dirs {
+		d := DiskHealth{Path: dir}
+
+		if v, err := getFreeDiskMB(dir); err != nil {
+			problem("check free disk of %s, err:%s", dir, err.Error())
+		} else {
+			d.FreeMB = v
+			d.OK = v >= int64(b.cfg.MinFreeDisk)
+
+			if !d.OK {
+				problem("only %dMB free in %s", v, dir)
+			}
+		}
+
+		h.Disks = append(h.Disks, d)
+	}
+
+	h.Ready = len(h.Problems) == 0
+
+	return h
+}
+
+func getFreeDiskMB(dir string) (int64, error) {
+	// the build root may not be created yet.
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+
+		dir = parent
+	}
+
+	v := syscall.Statfs_t{}
+	if err := syscall.Statfs(dir, &v); err != nil {
+		return 0, err
+	}
+
+	return int64(v.Bavail) * int64(v.Bsize) >> 20, nil
+}
@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/zengchen1024/obs-worker/build"
+	"github.com/zengchen1024/obs-worker/metrics"
 	"github.com/zengchen1024/obs-worker/sdk/buildinfo"
 	"github.com/zengchen1024/obs-worker/sdk/workerstate"
 	"github.com/zengchen1024/obs-worker/utils"
@@ -19,10 +20,10 @@ type Job struct {
 }
 
 func (j *Job) Validate() error {
-	return instance.canBuild(&j.BuildInfo)
+	return instance.canBuild(j)
 }
 
-func (j *Job) Create(registerServer string) error {
+func (j *Job) Create(registerServer, workerId string) error {
 	if len(j.Paths) > 0 {
 		p := j.Paths[0]
 
@@ -39,9 +40,11 @@ func (j *Job) Create(registerServer string) error {
 		}
 	}
 
-	return instance.createJob(registerServer, j)
+	return instance.createJob(registerServer, workerId, j)
 }
 
+var errNotIdle = fmt.Errorf("I am not idle!\n")
+
 func (b *BuildManager) canBuild(info *buildinfo.BuildInfo) error {
 	if b.cfg.LocalKiwi != "" {
 		name := fmt.Sprintf("%s/%s", info.Arch, info.Job)
@@ -62,12 +65,20 @@ func (b *BuildManager) createJob(registerServer string, j *Job) error {
 	b.lock.Lock()
 	defer b.lock.Unlock()
 
+	if b.draining {
+		return ErrDraining
+	}
+
+	if b.upload != nil {
+		return ErrUploading
+	}
+
 	if b.state.State != workerstate.WorkerStateIdle {
-		return fmt.Errorf("I am not idle!\n")
+		return errNotIdle
 	}
 
 	v, _ := j.Marshal()
-	err := utils.WriteFile(filepath.Join(b.workDir, "job"), v)
+	err := utils.WriteFile(filepath.Join(b.cfg.StateDir, "job"), v)
 	if err != nil {
 		return err
 	}
@@ -82,6 +93,7 @@ func (b *BuildManager) createJob(registerServer string, j *Job) error {
 	}
 
 	b.job = job
+	b.jobDone = make(chan struct{})
 	b.nobadhost = j.NoBadHost
 
 	state := &b.state
@@ -112,42 +124,106 @@ func (b *BuildManager) createJob(registerServer string, j *Job) error {
 }
 
 func (b *BuildManager) runJob(jobId string, job build.Build) {
-	if _, err := job.DoBuild(jobId); err != nil {
+	code, err := job.DoBuild(jobId)
+	if err != nil {
 		utils.LogErr("do build job:%s, err:%s", jobId, err.Error())
 
-		stage := job.GetBuildStage()
-		switch stage {
-		}
+		job.AppenBuildLog(fmt.Sprintf("\n%s\n", err.Error()))
 	}
 
-	utils.LogInfo("I am idle again")
+	b.postBuid(jobId, job, code)
+
+	u := b.loadResultUpload()
 
+	if b.setIdle(u) && b.onDrained != nil {
+		b.onDrained()
+	}
+
+	if u != nil {
+		b.retryUpload(u)
+	}
+}
+
+// setIdle returns true if the slot is drained.
+func (b *BuildManager) setIdle(u *build.ResultUpload) bool {
 	b.lock.Lock()
 	defer b.lock.Unlock()
 
+	b.state.State = workerstate.WorkerStateIdle
+	b.upload = u
+
+	b.closeJobDone()
+
+	return b.becomeAvailable()
+}
+
+// becomeAvailable tells the repo servers the slot can accept job again.
+// It returns true if the slot is drained.
+func (b *BuildManager) becomeAvailable() bool {
+	if b.exiting {
+		return false
+	}
+
+	if b.upload != nil {
+		utils.LogInfo("wait for uploading the result of job:%s", b.upload.JobId())
+
+		return false
+	}
+
+	if b.draining {
+		utils.LogInfo("job is done, leave the repo servers for draining")
+
+		b.leave()
+
+		return true
+	}
+
+	utils.LogInfo("I am idle again")
+
 	b.sendIdleState()
 
-	b.state.State = workerstate.WorkerStateIdle
+	return false
+}
+
+func (b *BuildManager) postBuid(jobId string, job build.Build, code int) {
+	code, discard := b.genResultCode(code)
+	if discard {
+		utils.LogInfo("job:%s is discarded, skip uploading the result", jobId)
+
+		metrics.AddJob(workerstate.WorkerStateDiscarded)
+
+		return
+	}
+
+	metrics.AddJob(build.GenResultCode(code))
+
+	if err := job.PostBuild(jobId, code); err != nil {
+		utils.LogErr("post build job:%s, err:%s", jobId, err.Error())
+	}
 }
 
-func (b *BuildManager) postBuid(code int) {
+func (b *BuildManager) genResultCode(code int) (int, bool) {
 	b.lock.Lock()
 	defer b.lock.Unlock()
 
 	if b.checkIfDiscard() {
-		return
+		return code, true
 	}
 
-	state := &b.state
+	if b.opLog != "" {
+		b.job.AppenBuildLog(b.opLog)
+		b.opLog = ""
+	}
 
-	if s := state.State; s == workerstate.WorkerStateBadHost {
-		code = 3
+	if s := b.state.State; s == workerstate.WorkerStateBadHost {
+		code = build.BuildCodeBadHost
 	} else if s != workerstate.WorkerStateBuilding {
-		code = 1
+		code = build.BuildCodeFailed
 	}
 
+	return code, false
 }
 
 func (b *BuildManager) checkIfDiscard() bool {
-	return false
+	return b.state.State == workerstate.WorkerStateDiscarded
 }
@@ -0,0 +1,180 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/zengchen1024/obs-worker/build"
+	"github.com/zengchen1024/obs-worker/sdk/filereceiver"
+	"github.com/zengchen1024/obs-worker/utils"
+)
+
+var instance *slotManager
+
+var ErrSlotRequired = fmt.Errorf("jobid or workerid is required to find the slot")
+
+// GetBuildManager returns the slot which is building the job. If no slot
+// is building it, the slot registered as workerId is returned, or the
+// first slot if there is no such one. The returned slot will report the
+// right error when the job is not found.
+func GetBuildManager(jobId, workerId string) *BuildManager {
+	return instance.get(jobId, workerId)
+}
+
+// FindBuildManager is like GetBuildManager, but it fails instead of
+// returning the first slot when there are several slots and the request
+// specifies neither of them.
+func FindBuildManager(jobId, workerId string) (*BuildManager, error) {
+	m := instance
+
+	if jobId == "" && len(m.slots) > 1 && m.getById(workerId) == nil {
+		return nil, ErrSlotRequired
+	}
+
+	return m.get(jobId, workerId), nil
+}
+
+// GetBuildManagers returns all the slots.
+func GetBuildManagers() []*BuildManager {
+	return append([]*BuildManager{}, instance.slots...)
+}
+
+type slotManager struct {
+	slots []*BuildManager
+
+	drainer drainer
+}
+
+func (m *slotManager) get(jobId, workerId string) *BuildManager {
+	if jobId != "" {
+		for _, s := range m.slots {
+			if s.isBuildingJob(jobId) {
+				return s
+			}
+		}
+	}
+
+	if s := m.getById(workerId); s != nil {
+		return s
+	}
+
+	return m.slots[0]
+}
+
+func (m *slotManager) getById(workerId string) *BuildManager {
+	if workerId == "" {
+		return nil
+	}
+
+	for _, s := range m.slots {
+		if s.cfg.Id == workerId {
+			return s
+		}
+	}
+
+	return nil
+}
+
+func (m *slotManager) canBuild(j *Job) error {
+	return m.slots[0].canBuild(&j.BuildInfo)
+}
+
+func (m *slotManager) createJob(registerServer, workerId string, j *Job) error {
+	if s := m.getById(workerId); s != nil {
+		return s.createJob(registerServer, j)
+	}
+
+	for _, s := range m.slots {
+		if err := s.createJob(registerServer, j); err != errNotIdle && err != ErrUploading {
+			return err
+		}
+	}
+
+	return errNotIdle
+}
+
+func (m *slotManager) exit(policy string, timeout time.Duration) {
+	wg := sync.WaitGroup{}
+
+	for _, s := range m.slots {
+		wg.Add(1)
+
+		go func(b *BuildManager) {
+			defer wg.Done()
+
+			b.exit(policy, timeout)
+		}(s)
+	}
+
+	wg.Wait()
+}
+
+func Init(cfg *build.Config, port int) error {
+	if cfg.MaxFileSize > 0 {
+		filereceiver.MaxFileSize = int64(cfg.MaxFileSize) << 20
+	}
+
+	// it is checked before any job uses the cache.
+	if cfg.CacheCheck.OnStartup {
+		if _, err := build.CheckCache(cfg, cfg.CacheCheck.VerifyRPM); err != nil {
+			utils.LogErr("check cache, err:%s", err.Error())
+		}
+	}
+
+	cfgs := cfg.SlotConfigs()
+
+	if dir, err := os.Getwd(); err == nil {
+		removeStaleArgsFile(dir, cfgs)
+	}
+
+	m := slotManager{
+		slots: make([]*BuildManager, len(cfgs)),
+	}
+
+	for i := range cfgs {
+		b, err := newBuildManager(&cfgs[i], port)
+		if err != nil {
+			return err
+		}
+
+		b.onDrained = m.checkDrained
+		m.slots[i] = b
+	}
+
+	instance = &m
+
+	for _, b := range m.slots {
+		b.resumeUpload()
+	}
+
+	return nil
+}
+
+func Exit(policy string, timeout time.Duration) {
+	if instance != nil {
+		instance.exit(policy, timeout)
+	}
+}
+
+// removeStaleArgsFile removes the args file of obs-build which was written
+// to the work dir before it was moved to the state dir of every slot.
+func removeStaleArgsFile(dir string, cfgs []build.Config) {
+	for i := range cfgs {
+		if cfgs[i].StateDir == dir {
+			return
+		}
+	}
+
+	// it is not an executable obs-build, nor a directory of it.
+	f := filepath.Join(dir, "obs-build")
+	if v, err := os.Lstat(f); err != nil || !v.Mode().IsRegular() || v.Mode()&0111 != 0 {
+		return
+	}
+
+	if err := os.Remove(f); err != nil {
+		utils.LogErr("remove the stale args file of obs-build, err:%s", err.Error())
+	}
+}
@@ -0,0 +1,118 @@
+package worker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zengchen1024/obs-worker/build"
+	"github.com/zengchen1024/obs-worker/sdk/workerstate"
+)
+
+func newTestSlots(ids ...string) *slotManager {
+	m := &slotManager{}
+	for _, id := range ids {
+		m.slots = append(m.slots, &BuildManager{cfg: &build.Config{Id: id}})
+	}
+
+	return m
+}
+
+func TestFindBuildManager(t *testing.T) {
+	old := instance
+	defer func() { instance = old }()
+
+	instance = newTestSlots("w:1", "w:2")
+	instance.slots[1].state = workerstate.WorkerState{
+		State: workerstate.WorkerStateBuilding,
+		JobId: "job",
+	}
+
+	cases := []struct {
+		jobId    string
+		workerId string
+		want     int
+	}{
+		{"job", "", 1},
+		{"", "w:2", 1},
+		{"", "w:1", 0},
+		// the slot replies the error of the job.
+		{"other", "", 0},
+		{"", "", -1},
+		{"", "unknown", -1},
+	}
+
+	for _, c := range cases {
+		b, err := FindBuildManager(c.jobId, c.workerId)
+
+		if c.want < 0 {
+			if err != ErrSlotRequired {
+				t.Errorf("%s/%s: expect error, got %v", c.jobId, c.workerId, err)
+			}
+
+			continue
+		}
+
+		if err != nil || b != instance.slots[c.want] {
+			t.Errorf("%s/%s: got the wrong slot, err:%v", c.jobId, c.workerId, err)
+		}
+	}
+
+	// the only slot is found without the ids.
+	instance = newTestSlots("w:1")
+	if b, err := FindBuildManager("", ""); err != nil || b != instance.slots[0] {
+		t.Errorf("the only slot is not found, err:%v", err)
+	}
+}
+
+func TestRemoveStaleArgsFile(t *testing.T) {
+	dir := t.TempDir()
+	f := filepath.Join(dir, "obs-build")
+	cfgs := []build.Config{{StateDir: filepath.Join(dir, "1")}}
+
+	write := func(mode os.FileMode) {
+		if err := os.WriteFile(f, []byte("args"), mode); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chmod(f, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exists := func() bool {
+		_, err := os.Lstat(f)
+
+		return err == nil
+	}
+
+	write(0644)
+	removeStaleArgsFile(dir, []build.Config{{StateDir: dir}})
+	if !exists() {
+		t.Fatal("remove the args file of the slot")
+	}
+
+	removeStaleArgsFile(dir, cfgs)
+	if exists() {
+		t.Fatal("the stale args file is kept")
+	}
+
+	write(0755)
+	removeStaleArgsFile(dir, cfgs)
+	if !exists() {
+		t.Fatal("remove an executable obs-build")
+	}
+
+	if err := os.Remove(f); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Mkdir(f, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	removeStaleArgsFile(dir, cfgs)
+	if !exists() {
+		t.Fatal("remove the directory of obs-build")
+	}
+}
@@ -0,0 +1,122 @@
+package worker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zengchen1024/obs-worker/build"
+	"github.com/zengchen1024/obs-worker/sdk/workerstate"
+	"github.com/zengchen1024/obs-worker/utils"
+)
+
+var ErrUploading = fmt.Errorf("uploading the result of last job, it doesn't accept new job")
+
+func (b *BuildManager) loadResultUpload() *build.ResultUpload {
+	u, err := build.LoadResultUpload(b.cfg)
+	if err != nil {
+		utils.LogErr("load the spooled result, err:%s", err.Error())
+	}
+
+	return u
+}
+
+// resumeUpload retries the upload spooled before the worker restarted.
+func (b *BuildManager) resumeUpload() {
+	b.lock.RLock()
+	u := b.upload
+	b.lock.RUnlock()
+
+	if u == nil {
+		return
+	}
+
+	utils.LogInfo("resume uploading the result of job:%s", u.JobId())
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+
+		b.retryUpload(u)
+	}()
+}
+
+// retryUpload uploads the spooled result until it succeeds or is abandoned.
+// When the worker exits, it is tried once more and resumed after restarting.
+func (b *BuildManager) retryUpload(u *build.ResultUpload) {
+	defer b.finishUpload()
+
+	ctx := b.uploadCtx
+	policy := &b.cfg.ResultUpload
+	jobId := u.JobId()
+
+	for {
+		if reason := policy.Abandoned(u); reason != "" {
+			utils.LogErr("abandon the result of job:%s, %s", jobId, reason)
+
+			b.removeUpload(u)
+
+			return
+		}
+
+		exiting := false
+
+		select {
+		case <-b.uploadStop:
+			exiting = true
+		case <-time.After(policy.Wait(u.Attempts)):
+		}
+
+		err := u.Upload(ctx)
+		if err == nil {
+			utils.LogInfo("uploaded the result of job:%s", jobId)
+
+			b.removeUpload(u)
+
+			return
+		}
+
+		if ctx.Err() == nil {
+			if build.IsResultRejected(err) {
+				utils.LogErr("the result of job:%s is rejected, err:%s", jobId, err.Error())
+
+				b.removeUpload(u)
+
+				return
+			}
+
+			u.Attempts++
+
+			utils.LogErr(
+				"upload the result of job:%s, attempts:%d, err:%s",
+				jobId, u.Attempts, err.Error(),
+			)
+
+			if err := u.Save(); err != nil {
+				utils.LogErr("save the spooled result, err:%s", err.Error())
+			}
+		}
+
+		if exiting {
+			utils.LogInfo("stop uploading the result of job:%s", jobId)
+
+			return
+		}
+	}
+}
+
+func (b *BuildManager) removeUpload(u *build.ResultUpload) {
+	if err := u.Remove(); err != nil {
+		utils.LogErr("remove the spooled result, err:%s", err.Error())
+	}
+}
+
+func (b *BuildManager) finishUpload() {
+	b.lock.Lock()
+	b.upload = nil
+	drained := b.state.State == workerstate.WorkerStateIdle && b.becomeAvailable()
+	b.lock.Unlock()
+
+	if drained && b.onDrained != nil {
+		b.onDrained()
+	}
+}
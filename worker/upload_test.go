@@ -0,0 +1,95 @@
+package worker
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zengchen1024/obs-worker/build"
+	"github.com/zengchen1024/obs-worker/sdk/workerstate"
+)
+
+func (s *testSlot) uploading() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.upload != nil
+}
+
+func (s *testSlot) idle() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return s.state.State == workerstate.WorkerStateIdle
+}
+
+func (s *testSlot) spooled(t *testing.T) *build.ResultUpload {
+	u, err := build.LoadResultUpload(s.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return u
+}
+
+func (s *testSlot) putJobs() int {
+	n := 0
+	for _, v := range s.server.Requests() {
+		if strings.HasPrefix(v, "/putjob?") {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestRetryUploadSucceeds(t *testing.T) {
+	s := newTestSlot(t, build.ResultUploadPolicy{Backoff: 1, MaxBackoff: 1})
+
+	s.server.SetStatus("/putjob", 500)
+
+	s.build(t, 0)
+
+	waitFor(t, "spooling the result", 10*time.Second, func() bool {
+		return s.idle() && s.uploading()
+	})
+
+	// it doesn't accept job until the result is uploaded.
+	if err := s.createJob("", &Job{Id: "2", BuildInfo: s.info}); err != ErrUploading {
+		t.Fatalf("accept job when uploading, err:%v", err)
+	}
+
+	s.server.SetStatus("/putjob", 0)
+
+	waitFor(t, "uploading the result", 10*time.Second, func() bool {
+		return !s.uploading()
+	})
+
+	if v := s.server.Uploads(); len(v) != 1 || v[0].Code != "succeeded" {
+		t.Fatalf("got uploads:%+v", v)
+	}
+
+	if s.spooled(t) != nil {
+		t.Fatal("the spooled result is kept")
+	}
+}
+
+func TestRetryUploadAbandoned(t *testing.T) {
+	s := newTestSlot(t, build.ResultUploadPolicy{Backoff: 1, MaxBackoff: 1, MaxAttempts: 2})
+
+	s.server.SetStatus("/putjob", 500)
+
+	s.build(t, 0)
+
+	waitFor(t, "abandoning the result", 10*time.Second, func() bool {
+		return s.idle() && !s.uploading()
+	})
+
+	if n := s.putJobs(); n != 2 {
+		t.Fatalf("uploaded %d times", n)
+	}
+
+	if s.spooled(t) != nil {
+		t.Fatal("the abandoned result is kept")
+	}
+}
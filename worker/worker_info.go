@@ -6,6 +6,8 @@ import (
 	"regexp"
 	"strings"
 
+	"k8s.io/apimachinery/pkg/util/sets"
+
 	"github.com/zengchen1024/obs-worker/sdk/worker"
 	"github.com/zengchen1024/obs-worker/sdk/workerstate"
 	"github.com/zengchen1024/obs-worker/utils"
@@ -15,6 +17,8 @@ func (b *BuildManager) sendIdleState() {
 	state := workerstate.WorkerStateIdle
 	opts := b.genWorkerStateOpts(state)
 
+	registered := sets.NewString()
+
 	for _, server := range b.cfg.RepoServers {
 		utils.LogInfo("register to %s", server)
 
@@ -22,8 +26,12 @@ func (b *BuildManager) sendIdleState() {
 
 		if err := worker.Create(server, &opts, &b.w); err != nil {
 			utils.LogErr("send %s state, err:%v", state, err)
+		} else {
+			registered.Insert(server)
 		}
 	}
+
+	b.registered = registered
 }
 
 func (b *BuildManager) sendExitState() {